@@ -3,12 +3,14 @@ package main
 import (
 	"context"
 	_ "embed"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -55,30 +57,23 @@ func main() {
 	}
 
 	// Workaround for the circular depedency between the bot and the downloader.
-	var bot *telegram.Bot
-	replyFunc := func(chatId int64, text string) {
-		if bot == nil {
-			log.Printf("Cannot reply to chat %d: bot not initialized", chatId)
-			return
-		}
-		bot.SendReply(chatId, text)
-	}
-
-	down, err := torrents.NewDownloader(ctx, cfg, replyFunc)
+	messenger := &lazyMessenger{}
+	down, err := torrents.NewDownloader(ctx, cfg, messenger)
 	if err != nil {
 		log.Fatalf("Could not create the torrent downloader: %s", err)
 		os.Exit(1)
 	}
 	defer down.Shutdown()
 
+	pending := handlers.NewPendingDownloads()
 	handlers := []telegram.HandlerDesc{
 		{
 			Scope:   telegram.HANDLER_GLOBAL,
-			Handler: handlers.MakeTorrentFileHandler(),
+			Handler: handlers.MakeTorrentFileHandler(cfg, down, pending),
 		},
 		{
 			Scope:   telegram.HANDLER_GLOBAL,
-			Handler: handlers.MakeMagnetLinkHandler(cfg, down),
+			Handler: handlers.MakeMagnetLinkHandler(cfg, down, pending),
 		},
 		{
 			Scope:   telegram.HANDLER_COMMAND,
@@ -90,23 +85,123 @@ func main() {
 			Command: "status",
 			Handler: handlers.MakeStatusHandler(down),
 		},
+		{
+			Scope:   telegram.HANDLER_COMMAND,
+			Command: "library",
+			Handler: handlers.MakeLibraryHandler(down),
+		},
+		{
+			Scope:   telegram.HANDLER_COMMAND,
+			Command: "pause_all",
+			Handler: handlers.MakePauseAllHandler(down),
+		},
+		{
+			Scope:   telegram.HANDLER_COMMAND,
+			Command: "resume_all",
+			Handler: handlers.MakeResumeAllHandler(down),
+		},
 		{
 			Scope:   telegram.HANDLER_COMMAND,
 			Command: "help",
-			Handler: handlers.MakeHelpHandler([]string{"/ping", "/status"}, versionString()),
+			Handler: handlers.MakeHelpHandler([]string{"/ping", "/status", "/library", "/pause_all", "/resume_all"}, versionString()),
+		},
+		{
+			Scope:             telegram.HANDLER_MEMBERSHIP,
+			MembershipHandler: handlers.MakeMembershipHandler(),
 		},
 		{
 			Scope:   telegram.HANDLER_WILDCARD_COMMAND,
 			Command: "cancel",
 			Handler: handlers.MakeCancelHandler(down),
 		},
+		{
+			Scope:   telegram.HANDLER_WILDCARD_COMMAND,
+			Command: "category",
+			Handler: handlers.MakeChangeCategoryHandler(cfg),
+		},
+		{
+			Scope:   telegram.HANDLER_WILDCARD_COMMAND,
+			Command: "move",
+			Handler: handlers.MakeMoveHandler(cfg),
+		},
+		{
+			Scope:   telegram.HANDLER_WILDCARD_COMMAND,
+			Command: "retry",
+			Handler: handlers.MakeRetryHandler(down),
+		},
+		{
+			Scope:   telegram.HANDLER_WILDCARD_COMMAND,
+			Command: "top",
+			Handler: handlers.MakeQueueTopHandler(down),
+		},
+		{
+			Scope:   telegram.HANDLER_WILDCARD_COMMAND,
+			Command: "bottom",
+			Handler: handlers.MakeQueueBottomHandler(down),
+		},
+		{
+			Scope:   telegram.HANDLER_WILDCARD_COMMAND,
+			Command: "pause",
+			Handler: handlers.MakePauseHandler(down),
+		},
+		{
+			Scope:   telegram.HANDLER_WILDCARD_COMMAND,
+			Command: "resume",
+			Handler: handlers.MakeResumeHandler(down),
+		},
+		{
+			Scope:           telegram.HANDLER_CALLBACK,
+			Command:         handlers.ActionAdd,
+			CallbackHandler: handlers.MakeAddCallbackHandler(cfg, down, pending),
+		},
+		{
+			Scope:           telegram.HANDLER_CALLBACK,
+			Command:         handlers.ActionAbort,
+			CallbackHandler: handlers.MakeAbortCallbackHandler(down, pending),
+		},
+		{
+			Scope:           telegram.HANDLER_CALLBACK,
+			Command:         handlers.ActionSetCategory,
+			CallbackHandler: handlers.MakeSetCategoryCallbackHandler(cfg, down),
+		},
+		{
+			Scope:           telegram.HANDLER_CALLBACK,
+			Command:         handlers.ActionMove,
+			CallbackHandler: handlers.MakeMoveCallbackHandler(cfg, down),
+		},
+		{
+			Scope:           telegram.HANDLER_CALLBACK,
+			Command:         handlers.ActionKeep,
+			CallbackHandler: handlers.MakeKeepCallbackHandler(),
+		},
+		{
+			Scope:           telegram.HANDLER_CALLBACK,
+			Command:         handlers.ActionPause,
+			CallbackHandler: handlers.MakePauseCallbackHandler(down),
+		},
+		{
+			Scope:           telegram.HANDLER_CALLBACK,
+			Command:         handlers.ActionResume,
+			CallbackHandler: handlers.MakeResumeCallbackHandler(down),
+		},
+		{
+			Scope:           telegram.HANDLER_CALLBACK,
+			Command:         handlers.ActionCancel,
+			CallbackHandler: handlers.MakeCancelCallbackHandler(down),
+		},
+		{
+			Scope:           telegram.HANDLER_CALLBACK,
+			Command:         handlers.ActionDetails,
+			CallbackHandler: handlers.MakeDetailsCallbackHandler(down),
+		},
 	}
 
-	bot, err = telegram.NewBot(cfg, handlers)
+	bot, err := telegram.NewBot(cfg, handlers)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "Could not create the Telegram bot:", err)
 		os.Exit(1)
 	}
+	messenger.setBot(bot)
 
 	err = bot.RunLoop(ctx)
 	if err != nil {
@@ -114,3 +209,62 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// lazyMessenger forwards messages to the bot once it is initialized.
+type lazyMessenger struct {
+	bot   *telegram.Bot
+	mutex sync.Mutex
+}
+
+func (m *lazyMessenger) setBot(bot *telegram.Bot) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.bot = bot
+}
+
+func (m *lazyMessenger) getBot() *telegram.Bot {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.bot
+}
+
+func (m *lazyMessenger) SendReply(chatId int64, text string) {
+	bot := m.getBot()
+	if bot == nil {
+		log.Printf("Cannot reply to chat %d: bot not initialized", chatId)
+		return
+	}
+	bot.SendReply(chatId, text)
+}
+
+func (m *lazyMessenger) SendMessage(chatId int64, text string) (int, error) {
+	bot := m.getBot()
+	if bot == nil {
+		return 0, errors.New("bot not initialized")
+	}
+	return bot.SendMessage(chatId, text)
+}
+
+func (m *lazyMessenger) EditMessage(chatId int64, messageId int, text string) error {
+	bot := m.getBot()
+	if bot == nil {
+		return errors.New("bot not initialized")
+	}
+	return bot.EditMessage(chatId, messageId, text)
+}
+
+func (m *lazyMessenger) SendDocument(chatId int64, filePath string, caption string) error {
+	bot := m.getBot()
+	if bot == nil {
+		return errors.New("bot not initialized")
+	}
+	return bot.SendDocument(chatId, filePath, caption)
+}
+
+func (m *lazyMessenger) MaxUploadSize() int64 {
+	bot := m.getBot()
+	if bot == nil {
+		return 0
+	}
+	return bot.MaxUploadSize()
+}
@@ -1,6 +1,9 @@
 package config
 
-import "sort"
+import (
+	"regexp"
+	"sort"
+)
 
 func (cfg *Config) Categories() []string {
 	categories := make([]string, 0, len(cfg.TargetDirs))
@@ -10,3 +13,40 @@ func (cfg *Config) Categories() []string {
 	sort.Strings(categories)
 	return categories
 }
+
+// Category returns the options of a category. Missing options are returned as zero values.
+func (cfg *Config) Category(category string) CategoryConfig {
+	options, found := cfg.CategoryOptions[category]
+	if !found {
+		return CategoryConfig{}
+	}
+	return *options
+}
+
+// SeedingPolicy returns the seeding rules for a category. Torrents are removed as soon as they complete if there are none.
+func (cfg *Config) SeedingPolicy(category string) SeedingConfig {
+	if seeding := cfg.Category(category).Seeding; seeding != nil {
+		return *seeding
+	}
+	if cfg.Seeding != nil {
+		return *cfg.Seeding
+	}
+	return SeedingConfig{}
+}
+
+// Placement returns how completed downloads of a category are placed into the library.
+// Unless configured, torrents that have to keep seeding are hardlinked and the rest are moved.
+func (cfg *Config) Placement(category string) string {
+	if placement := cfg.Category(category).Placement; placement != "" {
+		return placement
+	}
+	if cfg.SeedingPolicy(category).RequiresSeeding() {
+		return PlacementHardlink
+	}
+	return PlacementMove
+}
+
+// MatchRules returns the compiled classification rules of a category.
+func (cfg *Config) MatchRules(category string) []*regexp.Regexp {
+	return cfg.Category(category).matchRules
+}
@@ -4,7 +4,11 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/url"
 	"os"
+	"path"
+	"regexp"
+	"time"
 )
 
 type Config struct {
@@ -14,6 +18,131 @@ type Config struct {
 	WorkDir        string            `json:"work_dir"`
 	TargetDirs     map[string]string `json:"target_dirs"`
 	DatabasePath   string            `json:"database_path"`
+	// How often to update download progress messages, in seconds.
+	ProgressInterval int `json:"progress_interval,omitempty"`
+	// How many torrents can download at the same time. Zero means no limit.
+	MaxActiveDownloads int `json:"max_active_downloads,omitempty"`
+	// Default seeding rules for all categories.
+	Seeding *SeedingConfig `json:"seeding,omitempty"`
+	// Per-category options. Categories themselves are defined by TargetDirs.
+	CategoryOptions map[string]*CategoryConfig `json:"categories,omitempty"`
+	// Media server to notify about new files in the library.
+	MediaServer *MediaServerConfig `json:"media_server,omitempty"`
+	// Suggests categories for new torrents based on their contents.
+	Classifier *ClassifierConfig `json:"classifier,omitempty"`
+	// Receive updates over a webhook instead of polling for them.
+	Webhook *WebhookConfig `json:"webhook,omitempty"`
+	// Base URL of a self-hosted Bot API server, e.g. http://localhost:8081. Defaults to the official one.
+	APIEndpoint string `json:"api_endpoint,omitempty"`
+	// Base URL to download files sent to the bot from. Defaults to APIEndpoint.
+	FileEndpoint string `json:"file_endpoint,omitempty"`
+	// The Bot API server runs with --local and shares the file system with lich,
+	// so files are read and uploaded by path and the larger size limits apply.
+	APILocalMode bool `json:"api_local_mode,omitempty"`
+}
+
+// ClassifierConfig tells the classifier which categories hold which kind of content.
+// Empty categories are never suggested.
+type ClassifierConfig struct {
+	// Video with episode markers such as S01E02.
+	SeriesCategory string `json:"series_category,omitempty"`
+	// Other video.
+	VideoCategory string `json:"video_category,omitempty"`
+	AudioCategory string `json:"audio_category,omitempty"`
+	// Torrents classified with at least this confidence, between 0 and 1, are filed without asking.
+	// Zero means always ask.
+	AutoFileConfidence float64 `json:"auto_file_confidence,omitempty"`
+}
+
+type MediaServerConfig struct {
+	// MediaServerPlex or MediaServerJellyfin. Defaults to MediaServerPlex.
+	Type string `json:"type,omitempty"`
+	// Base URL of the server, e.g. http://localhost:32400.
+	Endpoint string `json:"endpoint"`
+	Token    string `json:"token"`
+	// Plex library section IDs keyed by category. Only categories listed here are refreshed by Plex.
+	// Jellyfin finds the library by path and does not need them.
+	Sections map[string]string `json:"sections,omitempty"`
+	// Categories that are never refreshed. Jellyfin refreshes all other categories.
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+type CategoryConfig struct {
+	// Overrides the session-wide seeding rules.
+	Seeding *SeedingConfig `json:"seeding,omitempty"`
+	// How completed downloads get into the library: PlacementMove, PlacementHardlink or PlacementCopy.
+	Placement string `json:"placement,omitempty"`
+	// Name of the directory created for torrents with several top-level entries.
+	// Supports {name}, {infohash}, {date} and {category}. Defaults to {name}.
+	NameTemplate string `json:"name_template,omitempty"`
+	// Arrangement of files in the target directory. LayoutSeries sorts episodes into Show/Season NN directories.
+	Layout string `json:"layout,omitempty"`
+	// Unpack zip, tar and tar.gz archives, including split ones, once they are placed into the library.
+	ExtractArchives bool `json:"extract_archives,omitempty"`
+	// Delete archives from the library after they are unpacked.
+	DeleteArchives bool `json:"delete_archives,omitempty"`
+	// Commands to run once the files are placed into the library.
+	OnComplete []*HookConfig `json:"on_complete,omitempty"`
+	// Case-insensitive regular expressions. Torrents with matching names are classified into the category.
+	Match []string `json:"match,omitempty"`
+	// Upload placed files of up to this many bytes to the chat the download was requested from. Zero disables it.
+	SendToChatMaxSize int64 `json:"send_to_chat_max_size,omitempty"`
+	// Send each placed directory as a single zip archive instead of file by file.
+	SendToChatZip bool `json:"send_to_chat_zip,omitempty"`
+
+	// Compiled Match, filled in by validateConfig.
+	matchRules []*regexp.Regexp
+}
+
+// HookConfig describes a command run after a download is placed into the library.
+// The command gets the details of the download in LICH_* environment variables.
+type HookConfig struct {
+	// The executable and its arguments. The command is not run through a shell.
+	Command []string `json:"command"`
+	// In seconds. Defaults to 10 minutes.
+	Timeout int `json:"timeout,omitempty"`
+	// Send the exit status of the command to the chat the download was requested from.
+	Notify bool `json:"notify,omitempty"`
+}
+
+// Placement modes. With PlacementHardlink and PlacementCopy the torrent keeps seeding from the work directory.
+const (
+	PlacementMove     = "move"
+	PlacementHardlink = "hardlink"
+	PlacementCopy     = "copy"
+)
+
+const (
+	MediaServerPlex     = "plex"
+	MediaServerJellyfin = "jellyfin"
+)
+
+// Library layouts. By default files are placed into the target directory as they are.
+const (
+	LayoutSeries = "series"
+)
+
+// SeedingConfig defines when a completed torrent stops seeding and gets removed.
+// A torrent is removed once it reaches MinRatio and has seeded for MinSeedHours, or once it has seeded for MaxSeedHours.
+type SeedingConfig struct {
+	MinRatio     float64 `json:"min_ratio,omitempty"`
+	MinSeedHours float64 `json:"min_seed_hours,omitempty"`
+	// Zero means no limit.
+	MaxSeedHours float64 `json:"max_seed_hours,omitempty"`
+}
+
+// WebhookConfig makes Telegram push updates to lich over HTTP(S).
+type WebhookConfig struct {
+	// Address to serve the webhook on, e.g. 127.0.0.1:8080.
+	ListenAddress string `json:"listen_address"`
+	// Public HTTPS URL Telegram sends updates to, e.g. through a reverse proxy. Its path is served on ListenAddress.
+	URL string `json:"url"`
+	// Sent by Telegram with every update to prove that it comes from Telegram.
+	SecretToken string `json:"secret_token"`
+	// Serve HTTPS with this certificate and key instead of plain HTTP. The certificate is uploaded to Telegram
+	// when registering the webhook, so it may be self-signed.
+	CertFile string `json:"cert_file,omitempty"`
+	KeyFile  string `json:"key_file,omitempty"`
 }
 
 type ProxyConfig struct {
@@ -24,6 +153,10 @@ type ProxyConfig struct {
 
 const UnsortedCategory = "unsorted"
 
+const defaultProgressInterval = 15 * time.Second
+
+const defaultHookTimeout = 10 * time.Minute
+
 func LoadConfig(path string) (*Config, error) {
 	file, err := os.Open(path)
 	if err != nil {
@@ -46,6 +179,18 @@ func LoadConfig(path string) (*Config, error) {
 	return &cfg, nil
 }
 
+// StateDatabasePath returns the path to lich's own database, which lives next to the torrent database.
+func (cfg *Config) StateDatabasePath() string {
+	return path.Join(path.Dir(cfg.DatabasePath), "lich.db")
+}
+
+func (cfg *Config) ProgressUpdateInterval() time.Duration {
+	if cfg.ProgressInterval <= 0 {
+		return defaultProgressInterval
+	}
+	return time.Duration(cfg.ProgressInterval) * time.Second
+}
+
 func validateConfig(cfg *Config) error {
 	if cfg.DatabasePath == "" {
 		return errors.New("Missing required option 'database_path'")
@@ -71,8 +216,166 @@ func validateConfig(cfg *Config) error {
 			return errors.New(msg)
 		}
 	}
+	for category, options := range cfg.CategoryOptions {
+		if _, found := cfg.TargetDirs[category]; !found {
+			return fmt.Errorf("Options given for unknown category '%s'", category)
+		}
+		if options == nil {
+			return fmt.Errorf("Empty options for category '%s'", category)
+		}
+		switch options.Placement {
+		case "", PlacementMove, PlacementHardlink, PlacementCopy:
+		default:
+			return fmt.Errorf("Invalid placement '%s' for category '%s'", options.Placement, category)
+		}
+		switch options.Layout {
+		case "", LayoutSeries:
+		default:
+			return fmt.Errorf("Invalid layout '%s' for category '%s'", options.Layout, category)
+		}
+		if options.DeleteArchives && !options.ExtractArchives {
+			return fmt.Errorf("Option 'delete_archives' requires 'extract_archives' for category '%s'", category)
+		}
+		for _, rule := range options.Match {
+			re, err := regexp.Compile("(?i)" + rule)
+			if err != nil {
+				return fmt.Errorf("Invalid 'match' rule '%s' for category '%s': %s", rule, category, err)
+			}
+			options.matchRules = append(options.matchRules, re)
+		}
+		for _, hook := range options.OnComplete {
+			if hook == nil || len(hook.Command) == 0 || hook.Command[0] == "" {
+				return fmt.Errorf("Empty 'on_complete' command for category '%s'", category)
+			}
+			if hook.Timeout < 0 {
+				return fmt.Errorf("Negative 'on_complete' timeout for category '%s'", category)
+			}
+		}
+		if options.SendToChatMaxSize < 0 {
+			return fmt.Errorf("Negative 'send_to_chat_max_size' for category '%s'", category)
+		}
+		if options.SendToChatZip && options.SendToChatMaxSize == 0 {
+			return fmt.Errorf("Option 'send_to_chat_zip' requires 'send_to_chat_max_size' for category '%s'", category)
+		}
+	}
+	if cfg.Classifier != nil {
+		err = validateClassifier(cfg)
+		if err != nil {
+			return err
+		}
+	}
+	err = validateAPIEndpoints(cfg)
+	if err != nil {
+		return err
+	}
+	if cfg.Webhook != nil {
+		err = validateWebhook(cfg.Webhook)
+		if err != nil {
+			return err
+		}
+	}
+	if cfg.MediaServer != nil {
+		err = validateMediaServer(cfg)
+		if err != nil {
+			return err
+		}
+	}
 	if !hasUnsortedCategory {
 		return fmt.Errorf("Required category '%s' not found", UnsortedCategory)
 	}
 	return nil
 }
+
+func validateClassifier(cfg *Config) error {
+	for _, category := range []string{cfg.Classifier.SeriesCategory, cfg.Classifier.VideoCategory, cfg.Classifier.AudioCategory} {
+		if _, found := cfg.TargetDirs[category]; category != "" && !found {
+			return fmt.Errorf("Classifier refers to unknown category '%s'", category)
+		}
+	}
+	if cfg.Classifier.AutoFileConfidence < 0 || cfg.Classifier.AutoFileConfidence > 1 {
+		return errors.New("Option 'classifier.auto_file_confidence' must be between 0 and 1")
+	}
+	return nil
+}
+
+func validateAPIEndpoints(cfg *Config) error {
+	for _, endpoint := range []struct{ option, value string }{
+		{"api_endpoint", cfg.APIEndpoint},
+		{"file_endpoint", cfg.FileEndpoint},
+	} {
+		if endpoint.value == "" {
+			continue
+		}
+		endpointURL, err := url.Parse(endpoint.value)
+		if err != nil || (endpointURL.Scheme != "http" && endpointURL.Scheme != "https") || endpointURL.Host == "" {
+			return fmt.Errorf("Option '%s' must be an HTTP(S) URL, got '%s'", endpoint.option, endpoint.value)
+		}
+	}
+	if cfg.APILocalMode && cfg.APIEndpoint == "" {
+		return errors.New("Option 'api_local_mode' requires 'api_endpoint'")
+	}
+	return nil
+}
+
+// Characters Telegram allows in the secret token of a webhook.
+var secretTokenRegex = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+
+func validateWebhook(webhook *WebhookConfig) error {
+	if webhook.ListenAddress == "" {
+		return errors.New("Missing required option 'webhook.listen_address'")
+	}
+	hookURL, err := url.Parse(webhook.URL)
+	if err != nil || hookURL.Scheme != "https" || hookURL.Host == "" {
+		return fmt.Errorf("Option 'webhook.url' must be an HTTPS URL, got '%s'", webhook.URL)
+	}
+	if !secretTokenRegex.MatchString(webhook.SecretToken) {
+		return errors.New("Option 'webhook.secret_token' must be 1 to 256 letters, digits, '_' or '-'")
+	}
+	if (webhook.CertFile == "") != (webhook.KeyFile == "") {
+		return errors.New("Options 'webhook.cert_file' and 'webhook.key_file' must be given together")
+	}
+	return nil
+}
+
+func validateMediaServer(cfg *Config) error {
+	switch cfg.MediaServer.Type {
+	case "", MediaServerPlex, MediaServerJellyfin:
+	default:
+		return fmt.Errorf("Invalid media server type '%s'", cfg.MediaServer.Type)
+	}
+	if cfg.MediaServer.Endpoint == "" {
+		return errors.New("Missing required option 'media_server.endpoint'")
+	}
+	for category := range cfg.MediaServer.Sections {
+		if _, found := cfg.TargetDirs[category]; !found {
+			return fmt.Errorf("Media server section given for unknown category '%s'", category)
+		}
+	}
+	for _, category := range cfg.MediaServer.Exclude {
+		if _, found := cfg.TargetDirs[category]; !found {
+			return fmt.Errorf("Unknown category '%s' excluded from media server refresh", category)
+		}
+	}
+	return nil
+}
+
+func (hook *HookConfig) TimeoutDuration() time.Duration {
+	if hook.Timeout <= 0 {
+		return defaultHookTimeout
+	}
+	return time.Duration(hook.Timeout) * time.Second
+}
+
+// RequiresSeeding reports whether torrents have to keep seeding after they complete.
+func (seeding SeedingConfig) RequiresSeeding() bool {
+	return seeding.MinRatio > 0 || seeding.MinSeedHours > 0
+}
+
+// Satisfied reports whether a torrent with the given ratio and seeding time can be removed.
+func (seeding SeedingConfig) Satisfied(ratio float64, seededFor time.Duration) bool {
+	hours := seededFor.Hours()
+	if seeding.MaxSeedHours > 0 && hours >= seeding.MaxSeedHours {
+		return true
+	}
+	return ratio >= seeding.MinRatio && hours >= seeding.MinSeedHours
+}
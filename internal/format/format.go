@@ -0,0 +1,51 @@
+package format
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const progressBarWidth = 10
+
+func Bytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func Speed(bytesPerSecond int) string {
+	return Bytes(int64(bytesPerSecond)) + "/s"
+}
+
+func ETA(eta *time.Duration) string {
+	if eta == nil {
+		return "∞"
+	}
+	return eta.String()
+}
+
+func Percent(completed, total int64) string {
+	if total <= 0 {
+		return "?%"
+	}
+	return fmt.Sprintf("%d%%", completed*100/total)
+}
+
+func ProgressBar(completed, total int64) string {
+	filled := 0
+	if total > 0 {
+		filled = int(completed * progressBarWidth / total)
+	}
+	if filled > progressBarWidth {
+		filled = progressBarWidth
+	}
+	return strings.Repeat("█", filled) + strings.Repeat("░", progressBarWidth-filled)
+}
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/iley/lich/internal/config"
+	"github.com/iley/lich/internal/telegram"
+	"github.com/iley/lich/internal/torrents"
+)
+
+const maxLibraryItems = 10
+
+// Size of the category hash carried by the category buttons.
+const categoryKeySize = 6
+
+// MakeChangeCategoryHandler lets the user pick another category for a download that is not in the library yet.
+func MakeChangeCategoryHandler(cfg *config.Config) telegram.Handler {
+	return func(bot *telegram.Bot, msg *tgbotapi.Message) (bool, telegram.Handler, error) {
+		torrentId := strings.TrimPrefix(msg.Text, "/category_")
+		torrentId = strings.TrimSpace(torrentId)
+		err := askCategory(bot, cfg, msg.Chat.ID, fmt.Sprintf("Pick the new category for torrent %s", torrentId), ActionSetCategory, torrentId)
+		return true, nil, err
+	}
+}
+
+// MakeMoveHandler lets the user move a download that is already in the library to another category.
+func MakeMoveHandler(cfg *config.Config) telegram.Handler {
+	return func(bot *telegram.Bot, msg *tgbotapi.Message) (bool, telegram.Handler, error) {
+		torrentId := strings.TrimPrefix(msg.Text, "/move_")
+		torrentId = strings.TrimSpace(torrentId)
+		err := askCategory(bot, cfg, msg.Chat.ID, fmt.Sprintf("Where should torrent %s be moved?", torrentId), ActionMove, torrentId)
+		return true, nil, err
+	}
+}
+
+// MakeSetCategoryCallbackHandler changes the category of a download that is not in the library yet.
+func MakeSetCategoryCallbackHandler(cfg *config.Config, down *torrents.Downloader) telegram.CallbackHandler {
+	return func(bot *telegram.Bot, query *tgbotapi.CallbackQuery, args []string) (string, error) {
+		if len(args) != 2 {
+			return "", fmt.Errorf("Malformed button")
+		}
+		torrentId := args[0]
+		category, found := categoryByKey(cfg, args[1])
+		if !found {
+			return "", fmt.Errorf("Unknown category")
+		}
+		err := down.SetCategory(torrentId, category)
+		if err != nil {
+			return "", fmt.Errorf("Could not change category: %w", err)
+		}
+		bot.ClearButtons(query)
+		bot.SendReply(query.Message.Chat.ID, fmt.Sprintf("Torrent %s will be placed into %s", torrentId, category))
+		return fmt.Sprintf("Filed as %s", category), nil
+	}
+}
+
+// MakeMoveCallbackHandler moves a download that is already in the library to the category of the pressed button.
+func MakeMoveCallbackHandler(cfg *config.Config, down *torrents.Downloader) telegram.CallbackHandler {
+	return func(bot *telegram.Bot, query *tgbotapi.CallbackQuery, args []string) (string, error) {
+		if len(args) != 2 {
+			return "", fmt.Errorf("Malformed button")
+		}
+		torrentId := args[0]
+		category, found := categoryByKey(cfg, args[1])
+		if !found {
+			return "", fmt.Errorf("Unknown category")
+		}
+		chatId := query.Message.Chat.ID
+		bot.ClearButtons(query)
+		// Moves between file systems can take a while, the button press has to be answered before that.
+		go func() {
+			item, err := down.MoveLibraryItem(torrentId, category, chatId)
+			if err != nil {
+				bot.SendReply(chatId, fmt.Sprintf("Could not move torrent %s: %s", torrentId, err.Error()))
+				return
+			}
+			bot.SendReply(chatId, fmt.Sprintf("Moved %s to %s", item.Name, category))
+		}()
+		return fmt.Sprintf("Moving to %s", category), nil
+	}
+}
+
+// MakeKeepCallbackHandler dismisses a category prompt without changing anything.
+func MakeKeepCallbackHandler() telegram.CallbackHandler {
+	return func(bot *telegram.Bot, query *tgbotapi.CallbackQuery, args []string) (string, error) {
+		bot.ClearButtons(query)
+		return "Nothing changed", nil
+	}
+}
+
+func MakeLibraryHandler(down *torrents.Downloader) telegram.Handler {
+	return func(bot *telegram.Bot, msg *tgbotapi.Message) (bool, telegram.Handler, error) {
+		items, err := down.Library()
+		if err != nil {
+			return true, nil, fmt.Errorf("Could not list the library: %w", err)
+		}
+		if len(items) == 0 {
+			bot.SendReply(msg.Chat.ID, "Nothing has been placed into the library yet")
+			return true, nil, nil
+		}
+		if len(items) > maxLibraryItems {
+			items = items[:maxLibraryItems]
+		}
+		textEntries := make([]string, len(items))
+		for i, item := range items {
+			textEntries[i] = fmt.Sprintf("%d: [%s] %s\n%s\n/move_%s",
+				i+1, item.Category, item.Name, item.PlacedAt.Format("2006-01-02 15:04"), item.TorrentId)
+		}
+		fullText := fmt.Sprintf("Recently placed:\n%s", strings.Join(textEntries, "\n\n"))
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fullText))
+		return true, nil, nil
+	}
+}
+
+// categoryKey returns the short hash of a category carried by the category buttons in place of its name,
+// which may be too long for the button data. Unlike a position in the list, it stays valid when categories are
+// added or removed.
+func categoryKey(category string) string {
+	sum := sha256.Sum256([]byte(category))
+	return base64.RawURLEncoding.EncodeToString(sum[:categoryKeySize])
+}
+
+// categoryByKey finds the configured category with the given categoryKey.
+func categoryByKey(cfg *config.Config, key string) (string, bool) {
+	for _, category := range cfg.Categories() {
+		if categoryKey(category) == key {
+			return category, true
+		}
+	}
+	return "", false
+}
+
+// askCategory sends inline buttons that call action with torrentId and the picked category.
+func askCategory(bot *telegram.Bot, cfg *config.Config, chatId int64, text string, action string, torrentId string) error {
+	keyboard, err := categoryKeyboard(bot, chatId, cfg.Categories(), action, torrentId)
+	if err != nil {
+		return err
+	}
+	keep, err := bot.CallbackButton(chatId, "Abort", ActionKeep)
+	if err != nil {
+		return err
+	}
+	keyboard = append(keyboard, tgbotapi.NewInlineKeyboardRow(keep))
+	reply := tgbotapi.NewMessage(chatId, text)
+	reply.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(keyboard...)
+	bot.Send(reply)
+	return nil
+}
@@ -0,0 +1,18 @@
+package handlers
+
+import (
+	"log"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/iley/lich/internal/telegram"
+)
+
+// MakeMembershipHandler logs when the bot is added to or removed from a chat.
+func MakeMembershipHandler() telegram.MembershipHandler {
+	return func(bot *telegram.Bot, change *tgbotapi.ChatMemberUpdated) error {
+		log.Printf("Membership in chat %d changed from %s to %s by user %s",
+			change.Chat.ID, change.OldChatMember.Status, change.NewChatMember.Status, change.From.UserName)
+		return nil
+	}
+}
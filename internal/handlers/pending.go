@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/iley/lich/internal/torrents"
+)
+
+// Requests nobody picks a category for are dropped after this long. The downloader discards their torrents
+// on its own.
+const pendingDownloadTTL = 6 * time.Hour
+
+// PendingDownloads keeps the download requests waiting for the user to pick a category,
+// so that the category buttons only have to carry a short key.
+type PendingDownloads struct {
+	requests map[string]pendingDownload // Protected by mutex.
+	mutex    sync.Mutex
+}
+
+type pendingDownload struct {
+	request torrents.DownloadRequest
+	addedAt time.Time
+}
+
+func NewPendingDownloads() *PendingDownloads {
+	return &PendingDownloads{requests: make(map[string]pendingDownload)}
+}
+
+// put stores a request and returns the key to take it back with.
+func (p *PendingDownloads) put(request torrents.DownloadRequest) (string, error) {
+	buf := make([]byte, 12)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	key := base64.RawURLEncoding.EncodeToString(buf)
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.expire()
+	p.requests[key] = pendingDownload{request: request, addedAt: time.Now()}
+	return key, nil
+}
+
+// take removes a request, so that pressing a button twice does not start the download twice.
+func (p *PendingDownloads) take(key string) (torrents.DownloadRequest, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	pending, found := p.requests[key]
+	delete(p.requests, key)
+	return pending.request, found
+}
+
+// expire must be called under p.mutex.
+func (p *PendingDownloads) expire() {
+	for key, pending := range p.requests {
+		if time.Since(pending.addedAt) < pendingDownloadTTL {
+			continue
+		}
+		log.Printf("Nobody picked a category for %s, dropping it", pending.request.Name)
+		delete(p.requests, key)
+	}
+}
@@ -6,10 +6,14 @@ import (
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 
+	"github.com/iley/lich/internal/format"
 	"github.com/iley/lich/internal/telegram"
 	"github.com/iley/lich/internal/torrents"
 )
 
+// Telegram limits the number of inline buttons in a message, so only the first entries get them.
+const maxStatusButtonRows = 20
+
 func MakeStatusHandler(downloader *torrents.Downloader) telegram.Handler {
 	return func(bot *telegram.Bot, msg *tgbotapi.Message) (bool, telegram.Handler, error) {
 		list := downloader.List()
@@ -20,13 +24,144 @@ func MakeStatusHandler(downloader *torrents.Downloader) telegram.Handler {
 
 		textEntries := make([]string, len(list))
 		for i, entry := range list {
-			cancelCommand := fmt.Sprintf("/cancel_%s", entry.TorrentId)
-			textEntries[i] = fmt.Sprintf("%d: [%s] %s (%s)", i+1, entry.Category, entry.Name, cancelCommand)
+			textEntries[i] = fmt.Sprintf("%d: %s", i+1, formatListEntry(entry))
 		}
 
-		fullText := fmt.Sprintf("Active downloads:\n%s", strings.Join(textEntries, "\n"))
+		fullText := fmt.Sprintf("Active downloads:\n%s", strings.Join(textEntries, "\n\n"))
 		reply := tgbotapi.NewMessage(msg.Chat.ID, fullText)
+		keyboard, err := statusKeyboard(bot, msg.Chat.ID, list)
+		if err != nil {
+			return true, nil, err
+		}
+		reply.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(keyboard...)
 		bot.Send(reply)
 		return true, nil, nil
 	}
 }
+
+// statusKeyboard makes a row of buttons for each entry, numbered like the entries in the text.
+func statusKeyboard(bot *telegram.Bot, chatId int64, list []torrents.DownloadListEntry) ([][]tgbotapi.InlineKeyboardButton, error) {
+	keyboard := [][]tgbotapi.InlineKeyboardButton{}
+	for i, entry := range list {
+		if i == maxStatusButtonRows {
+			break
+		}
+		type button struct{ text, action string }
+		buttons := []button{}
+		switch entry.Status {
+		case torrents.StatusPaused:
+			buttons = append(buttons, button{"Resume", ActionResume})
+		case torrents.StatusError:
+			// Failed downloads are restarted with /retry_<id> rather than resumed.
+		default:
+			buttons = append(buttons, button{"Pause", ActionPause})
+		}
+		buttons = append(buttons, button{"Cancel", ActionCancel}, button{"Details", ActionDetails})
+		row := make([]tgbotapi.InlineKeyboardButton, len(buttons))
+		for j, b := range buttons {
+			var err error
+			row[j], err = bot.CallbackButton(chatId, fmt.Sprintf("%d: %s", i+1, b.text), b.action, entry.TorrentId)
+			if err != nil {
+				return nil, err
+			}
+		}
+		keyboard = append(keyboard, row)
+	}
+	return keyboard, nil
+}
+
+func MakePauseCallbackHandler(down *torrents.Downloader) telegram.CallbackHandler {
+	return func(bot *telegram.Bot, query *tgbotapi.CallbackQuery, args []string) (string, error) {
+		if len(args) != 1 {
+			return "", fmt.Errorf("Malformed button")
+		}
+		err := down.Pause(args[0])
+		if err != nil {
+			return "", fmt.Errorf("Could not pause: %w", err)
+		}
+		return "Paused", nil
+	}
+}
+
+func MakeResumeCallbackHandler(down *torrents.Downloader) telegram.CallbackHandler {
+	return func(bot *telegram.Bot, query *tgbotapi.CallbackQuery, args []string) (string, error) {
+		if len(args) != 1 {
+			return "", fmt.Errorf("Malformed button")
+		}
+		err := down.Resume(args[0])
+		if err != nil {
+			return "", fmt.Errorf("Could not resume: %w", err)
+		}
+		return "Resumed", nil
+	}
+}
+
+func MakeCancelCallbackHandler(down *torrents.Downloader) telegram.CallbackHandler {
+	return func(bot *telegram.Bot, query *tgbotapi.CallbackQuery, args []string) (string, error) {
+		if len(args) != 1 {
+			return "", fmt.Errorf("Malformed button")
+		}
+		err := down.Cancel(args[0])
+		if err != nil {
+			return "", fmt.Errorf("Could not cancel: %w", err)
+		}
+		return "Cancelled", nil
+	}
+}
+
+// MakeDetailsCallbackHandler sends the current status of a single download.
+func MakeDetailsCallbackHandler(down *torrents.Downloader) telegram.CallbackHandler {
+	return func(bot *telegram.Bot, query *tgbotapi.CallbackQuery, args []string) (string, error) {
+		if len(args) != 1 {
+			return "", fmt.Errorf("Malformed button")
+		}
+		entry, err := down.Entry(args[0])
+		if err != nil {
+			return "", fmt.Errorf("Could not get details: %w", err)
+		}
+		bot.SendReply(query.Message.Chat.ID, formatListEntry(entry))
+		return "", nil
+	}
+}
+
+func formatListEntry(entry torrents.DownloadListEntry) string {
+	lines := []string{
+		fmt.Sprintf("[%s] %s", entry.Category, entry.Name),
+		fmt.Sprintf("%s %s of %s, %s",
+			format.ProgressBar(entry.BytesCompleted, entry.BytesTotal),
+			format.Percent(entry.BytesCompleted, entry.BytesTotal),
+			format.Bytes(entry.BytesTotal),
+			entry.Status),
+	}
+	switch entry.Status {
+	case torrents.StatusError:
+		lines = append(lines, fmt.Sprintf("Error: %s", entry.Error))
+		lines = append(lines, fmt.Sprintf("/retry_%s", entry.TorrentId))
+	case torrents.StatusDownloadingMetadata, torrents.StatusDownloading, torrents.StatusSeeding:
+		line := fmt.Sprintf("↓ %s ↑ %s, peers %d, seeds %d",
+			format.Speed(entry.DownloadSpeed),
+			format.Speed(entry.UploadSpeed),
+			entry.Peers,
+			entry.Seeds)
+		switch entry.Status {
+		case torrents.StatusDownloading:
+			line += fmt.Sprintf(", ETA %s", format.ETA(entry.ETA))
+		case torrents.StatusSeeding:
+			line += fmt.Sprintf(", ratio %.2f", entry.Ratio)
+		}
+		lines = append(lines, line)
+		lines = append(lines, fmt.Sprintf("/pause_%s", entry.TorrentId))
+	case torrents.StatusPaused:
+		lines = append(lines, fmt.Sprintf("/resume_%s", entry.TorrentId))
+	case torrents.StatusQueued:
+		lines = append(lines, fmt.Sprintf("Position in queue: %d (/top_%s, /bottom_%s)",
+			entry.QueuePosition, entry.TorrentId, entry.TorrentId))
+	}
+	if entry.InLibrary {
+		lines = append(lines, fmt.Sprintf("/move_%s", entry.TorrentId))
+	} else {
+		lines = append(lines, fmt.Sprintf("/category_%s", entry.TorrentId))
+	}
+	lines = append(lines, fmt.Sprintf("/cancel_%s", entry.TorrentId))
+	return strings.Join(lines, "\n")
+}
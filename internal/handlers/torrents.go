@@ -1,81 +1,238 @@
 package handlers
 
 import (
-	"io"
 	"fmt"
-	"net/http"
+	"io"
 	"log"
+	"net/http"
+	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 
 	"github.com/iley/lich/internal/config"
+	"github.com/iley/lich/internal/format"
 	"github.com/iley/lich/internal/telegram"
 	"github.com/iley/lich/internal/torrents"
 )
 
-func MakeTorrentFileHandler() telegram.Handler {
+const (
+	metadataTimeout = time.Minute
+	maxPreviewFiles = 10
+	buttonsPerRow   = 3
+)
+
+// Actions of the inline buttons, passed as the command of HANDLER_CALLBACK handlers.
+const (
+	ActionAdd         = "add"
+	ActionAbort       = "abort"
+	ActionSetCategory = "set"
+	ActionMove        = "move"
+	ActionKeep        = "keep"
+	ActionPause       = "pause"
+	ActionResume      = "resume"
+	ActionCancel      = "cancel"
+	ActionDetails     = "info"
+)
+
+func MakeTorrentFileHandler(cfg *config.Config, down *torrents.Downloader, pending *PendingDownloads) telegram.Handler {
 	return func(bot *telegram.Bot, msg *tgbotapi.Message) (bool, telegram.Handler, error) {
 		if msg.Document == nil || msg.Document.FileID == "" || !isTorrent(msg.Document) {
 			return false, nil, nil
 		}
-		text := fmt.Sprintf("Received torrent file %s. Torrent file downloading not implemented.", msg.Document.FileName)
-		bot.SendReply(msg.Chat.ID, text)
-		return true, nil, nil
+		fileName := msg.Document.FileName
+		if msg.Document.FileSize > torrents.MaxTorrentFileSize {
+			return true, nil, fmt.Errorf("Torrent file %s is too large (%d bytes)", fileName, msg.Document.FileSize)
+		}
+		data, err := bot.DownloadFile(msg.Document.FileID, torrents.MaxTorrentFileSize)
+		if err != nil {
+			return true, nil, fmt.Errorf("Could not download torrent file %s: %w", fileName, err)
+		}
+		err = torrents.ValidateTorrentFile(data)
+		if err != nil {
+			return true, nil, fmt.Errorf("Torrent file %s is malformed: %w", fileName, err)
+		}
+		return prepareDownload(bot, cfg, down, pending, msg, torrents.DownloadRequest{TorrentFile: data})
 	}
 }
 
-func MakeMagnetLinkHandler(cfg *config.Config, down *torrents.Downloader) telegram.Handler {
+func MakeMagnetLinkHandler(cfg *config.Config, down *torrents.Downloader, pending *PendingDownloads) telegram.Handler {
 	return func(bot *telegram.Bot, msg *tgbotapi.Message) (bool, telegram.Handler, error) {
 		magnetLink := extractMagnetLink(msg.Text)
 		if magnetLink == "" {
 			return false, nil, nil
 		}
-		categories := cfg.Categories()
-		text := fmt.Sprintf("What category does this torrent belong to? (%s)", strings.Join(categories, ", "))
-		reply := tgbotapi.NewMessage(msg.Chat.ID, text)
-		reply.ReplyMarkup = tgbotapi.ReplyKeyboardMarkup{Keyboard: makeKeyboard(categories), OneTimeKeyboard: true}
-		bot.Send(reply)
-		return true, makeCategoryHandler(cfg, down, magnetLink), nil
+		bot.SendReply(msg.Chat.ID, "Fetching torrent metadata...")
+		return prepareDownload(bot, cfg, down, pending, msg, torrents.DownloadRequest{MagnetLink: magnetLink})
 	}
 }
 
-func makeCategoryHandler(cfg *config.Config, down *torrents.Downloader, torrentUrl string) telegram.Handler {
-	return func(bot *telegram.Bot, msg *tgbotapi.Message) (bool, telegram.Handler, error) {
-		category := msg.Text
-		_, found := cfg.TargetDirs[category]
-		if found {
-			request := torrents.DownloadRequest{MagnetLink: torrentUrl, Category: category, ChatId: msg.Chat.ID}
-			err := down.Add(&request)
+// prepareDownload shows what the torrent contains and asks the user for a category, suggesting the one picked
+// by the classifier first. Torrents the classifier is sure about are filed right away.
+func prepareDownload(bot *telegram.Bot, cfg *config.Config, down *torrents.Downloader, pending *PendingDownloads, msg *tgbotapi.Message, request torrents.DownloadRequest) (bool, telegram.Handler, error) {
+	chatId := msg.Chat.ID
+	preview, err := down.Prepare(&request, metadataTimeout)
+	if err != nil {
+		return true, nil, err
+	}
+	request.TorrentId = preview.TorrentId
+	request.Name = preview.Name
+
+	suggestion := torrents.Classify(cfg, preview)
+	categories := suggestFirst(cfg.Categories(), suggestion.Category)
+	if preview.TorrentId != "" && torrents.AutoFile(cfg, suggestion) {
+		request.Category = suggestion.Category
+		request.ChatId = chatId
+		request.Username = msg.From.UserName
+		err = down.Add(&request)
+		if err != nil {
+			return true, nil, err
+		}
+		others := categories[1:]
+		keyboard, err := categoryKeyboard(bot, chatId, others, ActionSetCategory, request.TorrentId)
+		if err != nil {
 			return true, nil, err
 		}
+		text := fmt.Sprintf("%s\n\nFiled as %s (%s). Pick another category to change it.",
+			formatPreview(preview), suggestion.Category, suggestion.Reason)
+		reply := tgbotapi.NewMessage(chatId, text)
+		if len(keyboard) > 0 {
+			reply.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(keyboard...)
+		}
+		bot.Send(reply)
+		return true, nil, nil
+	}
+
+	key, err := pending.put(request)
+	if err != nil {
+		if request.TorrentId != "" {
+			down.Discard(request.TorrentId)
+		}
+		return true, nil, fmt.Errorf("Could not keep the download: %w", err)
+	}
+	keyboard, err := categoryKeyboard(bot, chatId, categories, ActionAdd, key)
+	if err == nil {
+		var abort tgbotapi.InlineKeyboardButton
+		abort, err = bot.CallbackButton(chatId, "Abort", ActionAbort, key)
+		keyboard = append(keyboard, tgbotapi.NewInlineKeyboardRow(abort))
+	}
+	if err != nil {
+		pending.take(key)
+		if request.TorrentId != "" {
+			down.Discard(request.TorrentId)
+		}
+		return true, nil, err
+	}
+	text := fmt.Sprintf("%s\n\nWhat category does this torrent belong to?", formatPreview(preview))
+	if suggestion.Category != "" {
+		text += fmt.Sprintf("\nLooks like %s: %s", suggestion.Category, suggestion.Reason)
+	}
+	reply := tgbotapi.NewMessage(chatId, text)
+	reply.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(keyboard...)
+	bot.Send(reply)
+	return true, nil, nil
+}
+
+// MakeAddCallbackHandler starts the download waiting for the category of the pressed button.
+func MakeAddCallbackHandler(cfg *config.Config, down *torrents.Downloader, pending *PendingDownloads) telegram.CallbackHandler {
+	return func(bot *telegram.Bot, query *tgbotapi.CallbackQuery, args []string) (string, error) {
+		if len(args) != 2 {
+			return "", fmt.Errorf("Malformed button")
+		}
+		key := args[0]
+		category, found := categoryByKey(cfg, args[1])
+		if !found {
+			return "", fmt.Errorf("Unknown category")
+		}
+		request, found := pending.take(key)
+		bot.ClearButtons(query)
+		if !found {
+			return "", fmt.Errorf("This download is no longer waiting for a category")
+		}
+		request.Category = category
+		request.ChatId = query.Message.Chat.ID
+		request.Username = query.From.UserName
+		err := down.Add(&request)
+		if err != nil {
+			return "", fmt.Errorf("Could not start the download: %w", err)
+		}
+		return fmt.Sprintf("Filed as %s", category), nil
+	}
+}
 
-		text := fmt.Sprintf("Unknown category %s. Pick one of %s", category, strings.Join(cfg.Categories(), ", "))
-		bot.SendReply(msg.Chat.ID, text)
-		return true, makeCategoryHandler(cfg, down, torrentUrl), nil
+// MakeAbortCallbackHandler drops the download waiting for a category.
+func MakeAbortCallbackHandler(down *torrents.Downloader, pending *PendingDownloads) telegram.CallbackHandler {
+	return func(bot *telegram.Bot, query *tgbotapi.CallbackQuery, args []string) (string, error) {
+		if len(args) != 1 {
+			return "", fmt.Errorf("Malformed button")
+		}
+		request, found := pending.take(args[0])
+		bot.ClearButtons(query)
+		if found && request.TorrentId != "" {
+			down.Discard(request.TorrentId)
+		}
+		bot.SendReply(query.Message.Chat.ID, "Download aborted")
+		return "", nil
+	}
+}
+
+// suggestFirst moves the suggested category to the front, keeping the order of the rest.
+func suggestFirst(categories []string, suggested string) []string {
+	if suggested == "" {
+		return categories
+	}
+	result := []string{suggested}
+	for _, category := range categories {
+		if category != suggested {
+			result = append(result, category)
+		}
 	}
+	return result
 }
 
+func formatPreview(preview *torrents.Preview) string {
+	name := preview.Name
+	if name == "" {
+		name = "unknown torrent"
+	}
+	if preview.TorrentId == "" {
+		return fmt.Sprintf("%s\nCould not fetch metadata in %s", name, metadataTimeout)
+	}
+	lines := []string{fmt.Sprintf("%s (%s)", name, format.Bytes(preview.Size))}
+	for i, file := range preview.Files {
+		if i == maxPreviewFiles {
+			lines = append(lines, fmt.Sprintf("...and %d more", len(preview.Files)-maxPreviewFiles))
+			break
+		}
+		lines = append(lines, fmt.Sprintf("- %s (%s)", file.Name, format.Bytes(file.Size)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+const torrentMimeType = "application/x-bittorrent"
+
+// isTorrent recognizes torrent files by their extension in any case or by their MIME type.
 func isTorrent(document *tgbotapi.Document) bool {
-	return strings.HasSuffix(document.FileName, ".torrent")
+	return strings.EqualFold(filepath.Ext(document.FileName), ".torrent") || document.MimeType == torrentMimeType
 }
 
-func makeKeyboard(keys []string) [][]tgbotapi.KeyboardButton {
-	keysPerRow := 3
-	keyboard := [][]tgbotapi.KeyboardButton{}
-	for i := 0; i < len(keys); i += keysPerRow {
-		j := i + keysPerRow
-		if j > len(keys) {
-			j = len(keys)
+// categoryKeyboard makes rows of inline buttons, one per category, that call action with args followed by
+// the key of the category.
+func categoryKeyboard(bot *telegram.Bot, chatId int64, categories []string, action string, args ...string) ([][]tgbotapi.InlineKeyboardButton, error) {
+	keyboard := [][]tgbotapi.InlineKeyboardButton{}
+	for i, category := range categories {
+		button, err := bot.CallbackButton(chatId, category, action, append(args, categoryKey(category))...)
+		if err != nil {
+			return nil, err
 		}
-		row := make([]tgbotapi.KeyboardButton, j-i)
-		for k := 0; k < j-i; k++ {
-			row[k] = tgbotapi.NewKeyboardButton(keys[i+k])
+		if i%buttonsPerRow == 0 {
+			keyboard = append(keyboard, []tgbotapi.InlineKeyboardButton{})
 		}
-		keyboard = append(keyboard, row)
+		keyboard[len(keyboard)-1] = append(keyboard[len(keyboard)-1], button)
 	}
-	return keyboard
+	return keyboard, nil
 }
 
 var magnetLinkRegex = regexp.MustCompile(`magnet:\S+`)
@@ -125,3 +282,95 @@ func MakeCancelHandler(down *torrents.Downloader) telegram.Handler {
 		return true, nil, nil
 	}
 }
+
+func MakeQueueTopHandler(down *torrents.Downloader) telegram.Handler {
+	return func(bot *telegram.Bot, msg *tgbotapi.Message) (bool, telegram.Handler, error) {
+		torrentId := strings.TrimPrefix(msg.Text, "/top_")
+		torrentId = strings.TrimSpace(torrentId)
+		err := down.MoveToTop(torrentId)
+		if err != nil {
+			bot.SendReply(msg.Chat.ID, fmt.Sprintf("Could not move torrent %s: %s", torrentId, err.Error()))
+			return true, nil, nil
+		}
+		bot.SendReply(msg.Chat.ID, fmt.Sprintf("Torrent %s is now first in the queue", torrentId))
+		return true, nil, nil
+	}
+}
+
+func MakeQueueBottomHandler(down *torrents.Downloader) telegram.Handler {
+	return func(bot *telegram.Bot, msg *tgbotapi.Message) (bool, telegram.Handler, error) {
+		torrentId := strings.TrimPrefix(msg.Text, "/bottom_")
+		torrentId = strings.TrimSpace(torrentId)
+		err := down.MoveToBottom(torrentId)
+		if err != nil {
+			bot.SendReply(msg.Chat.ID, fmt.Sprintf("Could not move torrent %s: %s", torrentId, err.Error()))
+			return true, nil, nil
+		}
+		bot.SendReply(msg.Chat.ID, fmt.Sprintf("Torrent %s is now last in the queue", torrentId))
+		return true, nil, nil
+	}
+}
+
+func MakePauseHandler(down *torrents.Downloader) telegram.Handler {
+	return func(bot *telegram.Bot, msg *tgbotapi.Message) (bool, telegram.Handler, error) {
+		torrentId := strings.TrimPrefix(msg.Text, "/pause_")
+		torrentId = strings.TrimSpace(torrentId)
+		err := down.Pause(torrentId)
+		if err != nil {
+			bot.SendReply(msg.Chat.ID, fmt.Sprintf("Could not pause torrent %s: %s", torrentId, err.Error()))
+			return true, nil, nil
+		}
+		bot.SendReply(msg.Chat.ID, fmt.Sprintf("Paused torrent %s. Resume it with /resume_%s", torrentId, torrentId))
+		return true, nil, nil
+	}
+}
+
+func MakeResumeHandler(down *torrents.Downloader) telegram.Handler {
+	return func(bot *telegram.Bot, msg *tgbotapi.Message) (bool, telegram.Handler, error) {
+		torrentId := strings.TrimPrefix(msg.Text, "/resume_")
+		torrentId = strings.TrimSpace(torrentId)
+		err := down.Resume(torrentId)
+		if err != nil {
+			bot.SendReply(msg.Chat.ID, fmt.Sprintf("Could not resume torrent %s: %s", torrentId, err.Error()))
+			return true, nil, nil
+		}
+		bot.SendReply(msg.Chat.ID, fmt.Sprintf("Resumed torrent %s", torrentId))
+		return true, nil, nil
+	}
+}
+
+func MakePauseAllHandler(down *torrents.Downloader) telegram.Handler {
+	return func(bot *telegram.Bot, msg *tgbotapi.Message) (bool, telegram.Handler, error) {
+		count, err := down.PauseAll()
+		if err != nil {
+			bot.SendReply(msg.Chat.ID, fmt.Sprintf("Paused %d torrents, then failed: %s", count, err.Error()))
+			return true, nil, nil
+		}
+		bot.SendReply(msg.Chat.ID, fmt.Sprintf("Paused %d torrents", count))
+		return true, nil, nil
+	}
+}
+
+func MakeResumeAllHandler(down *torrents.Downloader) telegram.Handler {
+	return func(bot *telegram.Bot, msg *tgbotapi.Message) (bool, telegram.Handler, error) {
+		count, err := down.ResumeAll()
+		if err != nil {
+			bot.SendReply(msg.Chat.ID, fmt.Sprintf("Resumed %d torrents, then failed: %s", count, err.Error()))
+			return true, nil, nil
+		}
+		bot.SendReply(msg.Chat.ID, fmt.Sprintf("Resumed %d torrents", count))
+		return true, nil, nil
+	}
+}
+
+func MakeRetryHandler(down *torrents.Downloader) telegram.Handler {
+	return func(bot *telegram.Bot, msg *tgbotapi.Message) (bool, telegram.Handler, error) {
+		torrentId := strings.TrimPrefix(msg.Text, "/retry_")
+		torrentId = strings.TrimSpace(torrentId)
+		err := down.Retry(torrentId)
+		if err != nil {
+			bot.SendReply(msg.Chat.ID, fmt.Sprintf("Could not retry torrent %s: %s", torrentId, err.Error()))
+		}
+		return true, nil, nil
+	}
+}
@@ -0,0 +1,138 @@
+package mediaserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slices"
+
+	"github.com/iley/lich/internal/config"
+)
+
+const (
+	requestTimeout = 30 * time.Second
+	maxAttempts    = 6
+	// Doubles after every failed attempt.
+	initialBackoff = 30 * time.Second
+)
+
+// Client asks a media server to scan new files in the library.
+type Client struct {
+	config *config.MediaServerConfig
+	http   *http.Client
+	// Delay before the first retry.
+	backoff time.Duration
+}
+
+func NewClient(cfg *config.MediaServerConfig) *Client {
+	return &Client{
+		config:  cfg,
+		http:    &http.Client{Timeout: requestTimeout},
+		backoff: initialBackoff,
+	}
+}
+
+// RefreshWithRetries refreshes libraryPath in the library section configured for category, retrying with
+// exponential backoff until it succeeds, the attempts run out or ctx is cancelled. Excluded categories are
+// skipped, and so are categories without a section on Plex. Blocks until done, so it is meant to run in its
+// own goroutine.
+func (c *Client) RefreshWithRetries(ctx context.Context, category string, libraryPath string) {
+	if slices.Contains(c.config.Exclude, category) {
+		return
+	}
+	section, found := c.config.Sections[category]
+	if !found && c.config.Type != config.MediaServerJellyfin {
+		return
+	}
+	backoff := c.backoff
+	for attempt := 1; ; attempt++ {
+		err := c.Refresh(ctx, section, libraryPath)
+		if err == nil {
+			log.Printf("Requested media server refresh of %s", libraryPath)
+			return
+		}
+		if attempt == maxAttempts {
+			log.Printf("Giving up on media server refresh of %s: %s", libraryPath, err)
+			return
+		}
+		log.Printf("Could not refresh %s on media server, retrying in %s: %s", libraryPath, backoff, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// Refresh asks the media server to scan libraryPath.
+func (c *Client) Refresh(ctx context.Context, section string, libraryPath string) error {
+	var req *http.Request
+	var err error
+	switch c.config.Type {
+	case config.MediaServerJellyfin:
+		req, err = c.jellyfinRequest(ctx, libraryPath)
+	default:
+		req, err = c.plexRequest(ctx, section, libraryPath)
+	}
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("media server returned %s", resp.Status)
+	}
+	return nil
+}
+
+// plexRequest builds a partial scan request for a Plex library section.
+func (c *Client) plexRequest(ctx context.Context, section string, libraryPath string) (*http.Request, error) {
+	query := url.Values{}
+	query.Set("path", libraryPath)
+	endpoint := fmt.Sprintf("%s/library/sections/%s/refresh?%s",
+		strings.TrimSuffix(c.config.Endpoint, "/"), url.PathEscape(section), query.Encode())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Plex-Token", c.config.Token)
+	return req, nil
+}
+
+type jellyfinUpdate struct {
+	Path       string
+	UpdateType string
+}
+
+type jellyfinUpdates struct {
+	Updates []jellyfinUpdate
+}
+
+// jellyfinRequest reports a new path to Jellyfin, which finds the library containing it by itself.
+func (c *Client) jellyfinRequest(ctx context.Context, libraryPath string) (*http.Request, error) {
+	body, err := json.Marshal(jellyfinUpdates{Updates: []jellyfinUpdate{{Path: libraryPath, UpdateType: "Created"}}})
+	if err != nil {
+		return nil, err
+	}
+	endpoint := strings.TrimSuffix(c.config.Endpoint, "/") + "/Library/Media/Updated"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Emby-Token", c.config.Token)
+	return req, nil
+}
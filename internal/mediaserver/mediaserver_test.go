@@ -0,0 +1,175 @@
+package mediaserver
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iley/lich/internal/config"
+)
+
+// request is what the test server saw of a request.
+type request struct {
+	method string
+	path   string
+	query  map[string][]string
+	header http.Header
+	body   []byte
+}
+
+// newTestServer responds with the given statuses in turn, and with 200 once they run out.
+func newTestServer(t *testing.T, statuses ...int) (*httptest.Server, func() []request) {
+	t.Helper()
+	var mutex sync.Mutex
+	requests := make([]request, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mutex.Lock()
+		defer mutex.Unlock()
+		requests = append(requests, request{
+			method: r.Method,
+			path:   r.URL.Path,
+			query:  r.URL.Query(),
+			header: r.Header.Clone(),
+			body:   body,
+		})
+		status := http.StatusOK
+		if len(requests) <= len(statuses) {
+			status = statuses[len(requests)-1]
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []request {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]request(nil), requests...)
+	}
+}
+
+func newTestClient(cfg *config.MediaServerConfig) *Client {
+	client := NewClient(cfg)
+	client.backoff = time.Millisecond
+	return client
+}
+
+func TestPlexRefresh(t *testing.T) {
+	server, requests := newTestServer(t)
+	client := newTestClient(&config.MediaServerConfig{
+		Endpoint: server.URL + "/",
+		Token:    "secret",
+		Sections: map[string]string{"movies": "3"},
+	})
+
+	client.RefreshWithRetries(context.Background(), "movies", "/library/movies/Some Film (2020)")
+
+	got := requests()
+	if len(got) != 1 {
+		t.Fatalf("server got %d requests, want 1", len(got))
+	}
+	if got[0].method != http.MethodGet || got[0].path != "/library/sections/3/refresh" {
+		t.Errorf("server got %s %s, want GET /library/sections/3/refresh", got[0].method, got[0].path)
+	}
+	if path := got[0].query["path"]; len(path) != 1 || path[0] != "/library/movies/Some Film (2020)" {
+		t.Errorf("path query is %q, want the library path", path)
+	}
+	if token := got[0].header.Get("X-Plex-Token"); token != "secret" {
+		t.Errorf("X-Plex-Token is %q, want %q", token, "secret")
+	}
+}
+
+func TestJellyfinRefresh(t *testing.T) {
+	server, requests := newTestServer(t)
+	client := newTestClient(&config.MediaServerConfig{
+		Type:     config.MediaServerJellyfin,
+		Endpoint: server.URL,
+		Token:    "secret",
+	})
+
+	client.RefreshWithRetries(context.Background(), "movies", "/library/movies/Some Film (2020)")
+
+	got := requests()
+	if len(got) != 1 {
+		t.Fatalf("server got %d requests, want 1", len(got))
+	}
+	if got[0].method != http.MethodPost || got[0].path != "/Library/Media/Updated" {
+		t.Errorf("server got %s %s, want POST /Library/Media/Updated", got[0].method, got[0].path)
+	}
+	if token := got[0].header.Get("X-Emby-Token"); token != "secret" {
+		t.Errorf("X-Emby-Token is %q, want %q", token, "secret")
+	}
+	if contentType := got[0].header.Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("Content-Type is %q, want application/json", contentType)
+	}
+	var body jellyfinUpdates
+	err := json.Unmarshal(got[0].body, &body)
+	if err != nil {
+		t.Fatalf("could not decode request body %q: %s", got[0].body, err)
+	}
+	want := jellyfinUpdate{Path: "/library/movies/Some Film (2020)", UpdateType: "Created"}
+	if len(body.Updates) != 1 || body.Updates[0] != want {
+		t.Errorf("request body is %+v, want one update %+v", body, want)
+	}
+}
+
+func TestRefreshRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		want     int
+	}{
+		{"server error", []int{500, 502}, 3},
+		{"gives up", []int{500, 500, 500, 500, 500, 500, 500}, maxAttempts},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, requests := newTestServer(t, tt.statuses...)
+			client := newTestClient(&config.MediaServerConfig{
+				Endpoint: server.URL,
+				Sections: map[string]string{"movies": "1"},
+			})
+
+			client.RefreshWithRetries(context.Background(), "movies", "/library/movies/film")
+
+			if got := len(requests()); got != tt.want {
+				t.Errorf("server got %d requests, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRefreshSkippedCategories(t *testing.T) {
+	tests := []struct {
+		name       string
+		serverType string
+		category   string
+		want       int
+	}{
+		{"plex without section", config.MediaServerPlex, "books", 0},
+		{"plex excluded", config.MediaServerPlex, "music", 0},
+		{"jellyfin without section", config.MediaServerJellyfin, "books", 1},
+		{"jellyfin excluded", config.MediaServerJellyfin, "music", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, requests := newTestServer(t)
+			client := newTestClient(&config.MediaServerConfig{
+				Type:     tt.serverType,
+				Endpoint: server.URL,
+				Sections: map[string]string{"movies": "1", "music": "2"},
+				Exclude:  []string{"music"},
+			})
+
+			client.RefreshWithRetries(context.Background(), tt.category, "/library/"+tt.category+"/item")
+
+			if got := len(requests()); got != tt.want {
+				t.Errorf("server got %d requests, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,9 +2,15 @@ package telegram
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
@@ -22,6 +28,25 @@ const (
 	HANDLER_COMMAND = iota
 	// Wildcard handlers are the same as command handlers except that the command can include an arbitrary suffix.
 	HANDLER_WILDCARD_COMMAND = iota
+	// The following scopes are for updates other than new messages and use the matching field of HandlerDesc.
+	HANDLER_EDITED_MESSAGE = iota
+	HANDLER_CALLBACK_QUERY = iota
+	HANDLER_INLINE_QUERY   = iota
+	HANDLER_MEMBERSHIP     = iota
+	// Callback handlers are called for presses of inline buttons made by CallbackButton with an exact action,
+	// which is passed as the command.
+	HANDLER_CALLBACK = iota
+)
+
+// Telegram limits for files uploaded by bots.
+const (
+	maxUploadSize      = 50 << 20
+	maxLocalUploadSize = 2000 << 20
+)
+
+const (
+	uploadAttempts   = 4
+	uploadRetryDelay = 5 * time.Second
 )
 
 type Handler func(*Bot, *tgbotapi.Message) (done bool, nextHandler Handler, err error)
@@ -30,6 +55,12 @@ type HandlerDesc struct {
 	Handler Handler
 	Command string
 	Scope   int
+
+	EditedMessageHandler EditedMessageHandler
+	CallbackQueryHandler CallbackQueryHandler
+	InlineQueryHandler   InlineQueryHandler
+	MembershipHandler    MembershipHandler
+	CallbackHandler      CallbackHandler
 }
 
 type WildcardHandler struct {
@@ -38,13 +69,17 @@ type WildcardHandler struct {
 }
 
 type Bot struct {
-	config           *config.Config         // Effectively immutable.
-	api              *tgbotapi.BotAPI       // Effectively immutable.
-	commandHandlers  map[string]Handler     // Effectively immutable.
-	globalHandlers   []Handler              // Effectively immutable.
-	wildcardHandlers []WildcardHandler      // Effectively immutable.
-	userWhiltelist   map[string]struct{}    // Effectively immutable.
-	chatSessions     map[int64]*chatSession // Protected by mutex.
+	config           *config.Config             // Effectively immutable.
+	api              *tgbotapi.BotAPI           // Effectively immutable.
+	commandHandlers  map[string]Handler         // Effectively immutable.
+	globalHandlers   []Handler                  // Effectively immutable.
+	wildcardHandlers []WildcardHandler          // Effectively immutable.
+	updateHandlers   updateHandlers             // Effectively immutable.
+	callbackHandlers map[string]CallbackHandler // Effectively immutable.
+	callbackKey      []byte                     // Effectively immutable.
+	fileEndpoint     string                     // Effectively immutable.
+	userWhiltelist   map[string]struct{}        // Effectively immutable.
+	chatSessions     map[int64]*chatSession     // Protected by mutex.
 	mutex            sync.Mutex
 }
 
@@ -52,6 +87,8 @@ func NewBot(cfg *config.Config, handlers []HandlerDesc) (*Bot, error) {
 	globalHandlers := make([]Handler, 0)
 	commandHandlers := make(map[string]Handler)
 	wildcardHandlers := make([]WildcardHandler, 0)
+	var updateHandlers updateHandlers
+	callbackHandlers := make(map[string]CallbackHandler)
 	for _, handlerDesc := range handlers {
 		switch handlerDesc.Scope {
 		case HANDLER_GLOBAL:
@@ -69,28 +106,51 @@ func NewBot(cfg *config.Config, handlers []HandlerDesc) (*Bot, error) {
 				Handler:  handlerDesc.Handler,
 				Wildcard: handlerDesc.Command,
 			})
+		case HANDLER_EDITED_MESSAGE:
+			if handlerDesc.EditedMessageHandler == nil {
+				return nil, fmt.Errorf("missing edited message handler")
+			}
+			updateHandlers.editedMessage = append(updateHandlers.editedMessage, handlerDesc.EditedMessageHandler)
+		case HANDLER_CALLBACK_QUERY:
+			if handlerDesc.CallbackQueryHandler == nil {
+				return nil, fmt.Errorf("missing callback query handler")
+			}
+			updateHandlers.callbackQuery = append(updateHandlers.callbackQuery, handlerDesc.CallbackQueryHandler)
+		case HANDLER_INLINE_QUERY:
+			if handlerDesc.InlineQueryHandler == nil {
+				return nil, fmt.Errorf("missing inline query handler")
+			}
+			updateHandlers.inlineQuery = append(updateHandlers.inlineQuery, handlerDesc.InlineQueryHandler)
+		case HANDLER_MEMBERSHIP:
+			if handlerDesc.MembershipHandler == nil {
+				return nil, fmt.Errorf("missing membership handler")
+			}
+			updateHandlers.membership = append(updateHandlers.membership, handlerDesc.MembershipHandler)
+		case HANDLER_CALLBACK:
+			if handlerDesc.Command == "" {
+				return nil, fmt.Errorf("empty action for callback handler")
+			}
+			if handlerDesc.CallbackHandler == nil {
+				return nil, fmt.Errorf("missing callback handler for action %s", handlerDesc.Command)
+			}
+			callbackHandlers[handlerDesc.Command] = handlerDesc.CallbackHandler
 		default:
 			return nil, fmt.Errorf("invalid handler scope %d", handlerDesc.Scope)
 		}
 	}
 
-	var api *tgbotapi.BotAPI
-	var err error
-	if cfg.Proxy == nil {
-		api, err = tgbotapi.NewBotAPI(cfg.Token)
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		httpClient, err := proxyHTTPClient(cfg.Proxy.Address, cfg.Proxy.Username, cfg.Proxy.Password)
-		if err != nil {
-			return nil, err
-		}
-		api, err = tgbotapi.NewBotAPIWithClient(cfg.Token, tgbotapi.APIEndpoint, httpClient)
+	httpClient := &http.Client{}
+	if cfg.Proxy != nil {
+		var err error
+		httpClient, err = proxyHTTPClient(cfg.Proxy.Address, cfg.Proxy.Username, cfg.Proxy.Password)
 		if err != nil {
 			return nil, err
 		}
 	}
+	api, err := tgbotapi.NewBotAPIWithClient(cfg.Token, apiEndpoint(cfg), httpClient)
+	if err != nil {
+		return nil, err
+	}
 
 	bot := Bot{
 		config:           cfg,
@@ -98,6 +158,10 @@ func NewBot(cfg *config.Config, handlers []HandlerDesc) (*Bot, error) {
 		commandHandlers:  commandHandlers,
 		globalHandlers:   globalHandlers,
 		wildcardHandlers: wildcardHandlers,
+		updateHandlers:   updateHandlers,
+		callbackHandlers: callbackHandlers,
+		callbackKey:      newCallbackKey(cfg.Token),
+		fileEndpoint:     fileEndpoint(cfg),
 		userWhiltelist:   make(map[string]struct{}),
 		chatSessions:     make(map[int64]*chatSession),
 	}
@@ -120,12 +184,21 @@ func (bot *Bot) UserAllowed(username string) bool {
 	return ok
 }
 
+// RunLoop receives updates until ctx is done, over the webhook if one is configured and by polling otherwise.
 func (bot *Bot) RunLoop(ctx context.Context) error {
 	log.Println("Running the Telegram bot")
+	go bot.RunGCLoop(ctx)
+	if bot.config.Webhook != nil {
+		return bot.runWebhook(ctx, bot.config.Webhook)
+	}
+	return bot.runPolling(ctx)
+}
+
+func (bot *Bot) runPolling(ctx context.Context) error {
+	bot.removeStaleWebhook()
 	updateConfig := tgbotapi.NewUpdate(0)
 	updateConfig.Timeout = 120
 	updatesChan := bot.api.GetUpdatesChan(updateConfig)
-	go bot.RunGCLoop(ctx)
 	for {
 		select {
 		case <-ctx.Done():
@@ -136,20 +209,7 @@ func (bot *Bot) RunLoop(ctx context.Context) error {
 				log.Println("Updates channel closed, shutting down the Telegram bot")
 				return nil
 			}
-			if bot.UserAllowed(update.Message.From.UserName) {
-				err := bot.EnqueueMessage(update.Message)
-				if err != nil {
-					log.Printf("Error enqueueing a message for chat %d: %s", update.Message.Chat.ID, err.Error())
-				}
-			} else {
-				log.Printf("Unauthorized access attempt from user %s", update.Message.From.UserName)
-				reply := tgbotapi.NewMessage(update.Message.Chat.ID, "I don't know you! Go away!")
-				reply.ReplyToMessageID = update.Message.MessageID
-				_, err := bot.api.Send(reply)
-				if err != nil {
-					log.Printf("error sending message: %v", err)
-				}
-			}
+			bot.dispatch(update)
 		}
 	}
 }
@@ -201,6 +261,163 @@ func (bot *Bot) SendReply(chatID int64, text string) {
 	bot.Send(reply)
 }
 
+// SendMessage sends a text message and returns its ID.
+func (bot *Bot) SendMessage(chatID int64, text string) (int, error) {
+	sent, err := bot.api.Send(tgbotapi.NewMessage(chatID, text))
+	if err != nil {
+		return 0, err
+	}
+	return sent.MessageID, nil
+}
+
+func (bot *Bot) EditMessage(chatID int64, messageID int, text string) error {
+	_, err := bot.api.Send(tgbotapi.NewEditMessageText(chatID, messageID, text))
+	return err
+}
+
+// DownloadFile fetches a file sent to the bot. Files larger than maxSize bytes are rejected.
+// A local Bot API server stores the files it receives on disk, so they are read from there.
+func (bot *Bot) DownloadFile(fileID string, maxSize int64) ([]byte, error) {
+	file, err := bot.api.GetFile(tgbotapi.FileConfig{FileID: fileID})
+	if err != nil {
+		return nil, err
+	}
+	if bot.config.APILocalMode && filepath.IsAbs(file.FilePath) {
+		localFile, err := os.Open(file.FilePath)
+		if err != nil {
+			return nil, err
+		}
+		defer localFile.Close()
+		return readLimited(localFile, maxSize)
+	}
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf(bot.fileEndpoint, bot.api.Token, file.FilePath), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := bot.api.Client.Do(req)
+	if err != nil {
+		return nil, redactToken(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected HTTP status %s", resp.Status)
+	}
+	return readLimited(resp.Body, maxSize)
+}
+
+func readLimited(r io.Reader, maxSize int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("file is larger than %d bytes", maxSize)
+	}
+	return data, nil
+}
+
+// SendDocument uploads a file to a chat. A local Bot API server reads the file from disk by itself.
+// Network errors, server errors and flood control are retried with increasing delays.
+func (bot *Bot) SendDocument(chatID int64, filePath string, caption string) error {
+	_, err := os.Stat(filePath)
+	if err != nil {
+		return err
+	}
+	delay := uploadRetryDelay
+	for attempt := 1; ; attempt++ {
+		err = bot.sendDocument(chatID, filePath, caption)
+		if err == nil {
+			return nil
+		}
+		wait, transient := uploadRetryAfter(err, delay)
+		if !transient || attempt == uploadAttempts {
+			return err
+		}
+		log.Printf("Could not upload %s to chat %d, retrying in %s: %s", filePath, chatID, wait, err)
+		time.Sleep(wait)
+		delay *= 2
+	}
+}
+
+func (bot *Bot) sendDocument(chatID int64, filePath string, caption string) error {
+	var document tgbotapi.DocumentConfig
+	if bot.config.APILocalMode {
+		absPath, err := filepath.Abs(filePath)
+		if err != nil {
+			return err
+		}
+		fileURL := url.URL{Scheme: "file", Path: absPath}
+		document = tgbotapi.NewDocument(chatID, tgbotapi.FileURL(fileURL.String()))
+	} else {
+		file, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		document = tgbotapi.NewDocument(chatID, tgbotapi.FileReader{Name: filepath.Base(filePath), Reader: file})
+	}
+	document.Caption = caption
+	_, err := bot.api.Send(document)
+	return redactToken(err)
+}
+
+// redactToken strips the request URL from errors of HTTP requests to the Bot API.
+// The URL contains the bot token, so it must not leak into error messages.
+func redactToken(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+	return err
+}
+
+// uploadRetryAfter tells whether a failed upload is worth retrying and how long to wait before that.
+// Errors reported by the Bot API are final unless they are about flood control or the server itself.
+func uploadRetryAfter(err error, delay time.Duration) (time.Duration, bool) {
+	var apiErr *tgbotapi.Error
+	if !errors.As(err, &apiErr) {
+		return delay, true
+	}
+	if apiErr.RetryAfter > 0 {
+		return time.Duration(apiErr.RetryAfter) * time.Second, true
+	}
+	return delay, apiErr.Code >= http.StatusInternalServerError
+}
+
+// MaxUploadSize returns the size of the largest file the Bot API server accepts from bots.
+func (bot *Bot) MaxUploadSize() int64 {
+	if bot.config.APILocalMode {
+		return maxLocalUploadSize
+	}
+	return maxUploadSize
+}
+
+// apiEndpoint returns the format of Bot API method URLs, with placeholders for the token and the method.
+func apiEndpoint(cfg *config.Config) string {
+	if cfg.APIEndpoint == "" {
+		return tgbotapi.APIEndpoint
+	}
+	return endpointFormat(cfg.APIEndpoint, "/bot")
+}
+
+// fileEndpoint returns the format of file download URLs, with placeholders for the token and the file path.
+func fileEndpoint(cfg *config.Config) string {
+	switch {
+	case cfg.FileEndpoint != "":
+		return endpointFormat(cfg.FileEndpoint, "/file/bot")
+	case cfg.APIEndpoint != "":
+		return endpointFormat(cfg.APIEndpoint, "/file/bot")
+	}
+	return tgbotapi.FileEndpoint
+}
+
+func endpointFormat(baseURL string, prefix string) string {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	return strings.ReplaceAll(baseURL, "%", "%%") + prefix + "%s/%s"
+}
+
+// proxyHTTPClient makes a client that connects through a SOCKS5 proxy, except to loopback addresses,
+// where a self-hosted Bot API server may run.
 func proxyHTTPClient(addr, username, password string) (*http.Client, error) {
 	var auth *proxy.Auth = nil
 	if username != "" || password != "" {
@@ -209,10 +426,12 @@ func proxyHTTPClient(addr, username, password string) (*http.Client, error) {
 			Password: password,
 		}
 	}
-	dialer, err := proxy.SOCKS5("tcp", addr, auth, proxy.Direct)
+	socks, err := proxy.SOCKS5("tcp", addr, auth, proxy.Direct)
 	if err != nil {
 		return nil, err
 	}
+	dialer := proxy.NewPerHost(socks, proxy.Direct)
+	dialer.AddFromString("localhost,127.0.0.0/8,::1")
 	httpTransport := &http.Transport{Dial: dialer.Dial}
 	httpClient := &http.Client{Transport: httpTransport}
 	return httpClient, nil
@@ -0,0 +1,131 @@
+package telegram
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	callbackSeparator = "|"
+	// Truncated HMAC, enough to stop forged button presses without eating into the data limit.
+	callbackMACSize = 8
+	// Telegram limit for the data of an inline button.
+	maxCallbackData = 64
+	// Telegram limit for the notification shown in response to a button press, in characters.
+	maxCallbackAnswer = 200
+)
+
+// CallbackHandler handles presses of inline buttons created with CallbackButton for its action.
+// The returned text is shown to the user as a notification and may be empty. So is the error, if any.
+type CallbackHandler func(bot *Bot, query *tgbotapi.CallbackQuery, args []string) (string, error)
+
+var errStaleButton = errors.New("This button no longer works")
+
+// CallbackButton makes an inline button that calls the handler registered for action with args when pressed.
+// The button data is signed, so it only works in the chat it was sent to and cannot be forged.
+func (bot *Bot) CallbackButton(chatID int64, text string, action string, args ...string) (tgbotapi.InlineKeyboardButton, error) {
+	data, err := bot.callbackData(chatID, action, args)
+	if err != nil {
+		return tgbotapi.InlineKeyboardButton{}, err
+	}
+	return tgbotapi.NewInlineKeyboardButtonData(text, data), nil
+}
+
+// ClearButtons removes the inline keyboard from the message with the pressed button.
+func (bot *Bot) ClearButtons(query *tgbotapi.CallbackQuery) {
+	if query.Message == nil {
+		return
+	}
+	empty := tgbotapi.InlineKeyboardMarkup{InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{}}
+	bot.Send(tgbotapi.NewEditMessageReplyMarkup(query.Message.Chat.ID, query.Message.MessageID, empty))
+}
+
+func (bot *Bot) callbackData(chatID int64, action string, args []string) (string, error) {
+	fields := append([]string{action}, args...)
+	for _, field := range fields {
+		if strings.Contains(field, callbackSeparator) {
+			return "", fmt.Errorf("callback argument %q contains %q", field, callbackSeparator)
+		}
+	}
+	payload := strings.Join(fields, callbackSeparator)
+	data := payload + callbackSeparator + bot.callbackMAC(chatID, payload)
+	if len(data) > maxCallbackData {
+		return "", fmt.Errorf("callback data for %s is %d bytes long, at most %d allowed", action, len(data), maxCallbackData)
+	}
+	return data, nil
+}
+
+// parseCallbackData checks the signature of the data of a pressed button and splits it into the action and args.
+func (bot *Bot) parseCallbackData(chatID int64, data string) (string, []string, error) {
+	i := strings.LastIndex(data, callbackSeparator)
+	if i < 0 {
+		return "", nil, fmt.Errorf("malformed callback data")
+	}
+	payload, mac := data[:i], data[i+1:]
+	if !hmac.Equal([]byte(mac), []byte(bot.callbackMAC(chatID, payload))) {
+		return "", nil, fmt.Errorf("invalid callback signature")
+	}
+	fields := strings.Split(payload, callbackSeparator)
+	return fields[0], fields[1:], nil
+}
+
+func (bot *Bot) callbackMAC(chatID int64, payload string) string {
+	mac := hmac.New(sha256.New, bot.callbackKey)
+	mac.Write([]byte(strconv.FormatInt(chatID, 10)))
+	mac.Write([]byte(callbackSeparator))
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:callbackMACSize])
+}
+
+// newCallbackKey derives the key for signing button data from the bot token, so that buttons keep working
+// across restarts.
+func newCallbackKey(token string) []byte {
+	key := sha256.Sum256([]byte("lich callback data:" + token))
+	return key[:]
+}
+
+// routeCallback passes a button press to the handler of its action and answers the query, which stops the spinner
+// on the button. Presses of buttons that were not made by CallbackButton are left to the callback query handlers,
+// if there are any.
+func (bot *Bot) routeCallback(query *tgbotapi.CallbackQuery) error {
+	var text string
+	var err error
+	if query.Message == nil || query.Message.Chat == nil {
+		// Buttons of inline messages are not tied to a chat, CallbackButton does not make them.
+		err = errStaleButton
+	} else if action, args, parseErr := bot.parseCallbackData(query.Message.Chat.ID, query.Data); parseErr != nil {
+		log.Printf("Rejecting callback query from user %s: %s", query.From.UserName, parseErr)
+		err = errStaleButton
+	} else if handler, found := bot.callbackHandlers[action]; !found {
+		log.Printf("No handler for callback action %s", action)
+		err = errStaleButton
+	} else {
+		text, err = handler(bot, query, args)
+	}
+	stale := err == errStaleButton
+	if stale && len(bot.updateHandlers.callbackQuery) > 0 {
+		return nil
+	}
+	if err != nil {
+		text = err.Error()
+	}
+	if runes := []rune(text); len(runes) > maxCallbackAnswer {
+		text = string(runes[:maxCallbackAnswer-1]) + "…"
+	}
+	_, answerErr := bot.api.Request(tgbotapi.NewCallback(query.ID, text))
+	if answerErr != nil {
+		return fmt.Errorf("could not answer callback query: %w", answerErr)
+	}
+	if stale {
+		return nil
+	}
+	return err
+}
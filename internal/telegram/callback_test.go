@@ -0,0 +1,115 @@
+package telegram
+
+import (
+	"strings"
+	"testing"
+)
+
+const testChatID = 123456789
+
+func newTestBot(token string) *Bot {
+	return &Bot{callbackKey: newCallbackKey(token)}
+}
+
+func TestCallbackDataRoundTrip(t *testing.T) {
+	bot := newTestBot("123:token")
+	tests := []struct {
+		name   string
+		action string
+		args   []string
+	}{
+		{"no args", "keep", nil},
+		{"one arg", "abort", []string{"Kx3Vb1aQ0mZ9Tt2pLw5R8c1d"}},
+		// The longest buttons: a torrent ID and a category key.
+		{"torrent and category", "move", []string{strings.Repeat("T", 22), "AbCdEf12"}},
+		{"empty arg", "info", []string{""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := bot.callbackData(testChatID, tt.action, tt.args)
+			if err != nil {
+				t.Fatalf("could not make callback data: %s", err)
+			}
+			if len(data) > maxCallbackData {
+				t.Fatalf("callback data %q is %d bytes long, Telegram allows %d", data, len(data), maxCallbackData)
+			}
+			action, args, err := bot.parseCallbackData(testChatID, data)
+			if err != nil {
+				t.Fatalf("could not parse callback data %q: %s", data, err)
+			}
+			if action != tt.action || strings.Join(args, ",") != strings.Join(tt.args, ",") || len(args) != len(tt.args) {
+				t.Errorf("parsed %q %q, want %q %q", action, args, tt.action, tt.args)
+			}
+		})
+	}
+}
+
+func TestCallbackDataRejected(t *testing.T) {
+	bot := newTestBot("123:token")
+	data, err := bot.callbackData(testChatID, "set", []string{"torrent", "category"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := strings.LastIndex(data, callbackSeparator)
+	payload, mac := data[:i], data[i+1:]
+
+	tests := []struct {
+		name   string
+		chatID int64
+		data   string
+		bot    *Bot
+	}{
+		{"tampered action", testChatID, "move" + strings.TrimPrefix(data, "set"), bot},
+		{"tampered argument", testChatID, strings.Replace(data, "torrent", "torrenT", 1), bot},
+		{"appended argument", testChatID, payload + callbackSeparator + "x" + callbackSeparator + mac, bot},
+		{"truncated signature", testChatID, data[:len(data)-1], bot},
+		{"empty signature", testChatID, payload + callbackSeparator, bot},
+		{"no signature", testChatID, "set", bot},
+		{"another chat", testChatID + 1, data, bot},
+		{"another bot token", testChatID, data, newTestBot("456:other")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			action, args, err := tt.bot.parseCallbackData(tt.chatID, tt.data)
+			if err == nil {
+				t.Errorf("parsed %q into %q %q, want an error", tt.data, action, args)
+			}
+		})
+	}
+}
+
+func TestCallbackDataLimits(t *testing.T) {
+	bot := newTestBot("123:token")
+	tests := []struct {
+		name   string
+		action string
+		args   []string
+	}{
+		{"separator in argument", "set", []string{"a|b"}},
+		{"separator in action", "a|b", nil},
+		// The signature and its separator take 12 bytes.
+		{"too long", "set", []string{strings.Repeat("x", maxCallbackData-len("set|")-callbackSignatureLength()+1)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := bot.callbackData(testChatID, tt.action, tt.args)
+			if err == nil {
+				t.Errorf("made callback data %q, want an error", data)
+			}
+		})
+	}
+
+	longest := strings.Repeat("x", maxCallbackData-len("set|")-callbackSignatureLength())
+	data, err := bot.callbackData(testChatID, "set", []string{longest})
+	if err != nil {
+		t.Fatalf("could not make callback data of exactly %d bytes: %s", maxCallbackData, err)
+	}
+	if len(data) != maxCallbackData {
+		t.Errorf("callback data is %d bytes long, want %d", len(data), maxCallbackData)
+	}
+}
+
+// callbackSignatureLength returns the length of the signature along with the separator before it.
+func callbackSignatureLength() int {
+	return len(callbackSeparator) + len(newTestBot("").callbackMAC(0, ""))
+}
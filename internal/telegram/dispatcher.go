@@ -0,0 +1,124 @@
+package telegram
+
+import (
+	"log"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Handlers for updates other than new messages. They run in their own goroutine, outside of chat sessions.
+type (
+	EditedMessageHandler func(*Bot, *tgbotapi.Message) error
+	CallbackQueryHandler func(*Bot, *tgbotapi.CallbackQuery) error
+	InlineQueryHandler   func(*Bot, *tgbotapi.InlineQuery) error
+	// Called when the bot itself or a member of a chat with the bot is added, removed, promoted and so on.
+	MembershipHandler func(*Bot, *tgbotapi.ChatMemberUpdated) error
+)
+
+// updateHandlers holds the handlers registered for each type of update other than new messages.
+type updateHandlers struct {
+	editedMessage []EditedMessageHandler
+	callbackQuery []CallbackQueryHandler
+	inlineQuery   []InlineQueryHandler
+	membership    []MembershipHandler
+}
+
+// dispatch routes an update to the handlers of its type after checking that the user it comes from is allowed.
+// Updates of unsupported types are ignored.
+func (bot *Bot) dispatch(update tgbotapi.Update) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Panic while handling update %d: %v", update.UpdateID, r)
+		}
+	}()
+
+	switch {
+	case update.Message != nil:
+		bot.dispatchMessage(update.Message)
+	case update.EditedMessage != nil:
+		message := update.EditedMessage
+		if !bot.authorized(message.From, "edited message") {
+			return
+		}
+		for _, handler := range bot.updateHandlers.editedMessage {
+			bot.runUpdateHandler("edited message", func() error { return handler(bot, message) })
+		}
+	case update.CallbackQuery != nil:
+		query := update.CallbackQuery
+		if !bot.authorized(query.From, "callback query") {
+			// Stop the spinner on the button anyway.
+			bot.api.Request(tgbotapi.NewCallback(query.ID, "I don't know you! Go away!"))
+			return
+		}
+		bot.runUpdateHandler("callback query", func() error { return bot.routeCallback(query) })
+		for _, handler := range bot.updateHandlers.callbackQuery {
+			bot.runUpdateHandler("callback query", func() error { return handler(bot, query) })
+		}
+	case update.InlineQuery != nil:
+		query := update.InlineQuery
+		if !bot.authorized(query.From, "inline query") {
+			return
+		}
+		for _, handler := range bot.updateHandlers.inlineQuery {
+			bot.runUpdateHandler("inline query", func() error { return handler(bot, query) })
+		}
+	case update.MyChatMember != nil || update.ChatMember != nil:
+		change := update.MyChatMember
+		if change == nil {
+			change = update.ChatMember
+		}
+		if !bot.authorized(&change.From, "membership change") {
+			return
+		}
+		for _, handler := range bot.updateHandlers.membership {
+			bot.runUpdateHandler("membership change", func() error { return handler(bot, change) })
+		}
+	default:
+		log.Printf("Ignoring update %d of an unsupported type", update.UpdateID)
+	}
+}
+
+func (bot *Bot) dispatchMessage(message *tgbotapi.Message) {
+	if message.Chat == nil {
+		log.Printf("Ignoring message %d without a chat", message.MessageID)
+		return
+	}
+	if !bot.authorized(message.From, "message") {
+		reply := tgbotapi.NewMessage(message.Chat.ID, "I don't know you! Go away!")
+		reply.ReplyToMessageID = message.MessageID
+		bot.Send(reply)
+		return
+	}
+	err := bot.EnqueueMessage(message)
+	if err != nil {
+		log.Printf("Error enqueueing a message for chat %d: %s", message.Chat.ID, err.Error())
+	}
+}
+
+// authorized checks the user an update comes from against the allowlist.
+// Updates without a user, such as messages sent on behalf of a channel, are never authorized.
+func (bot *Bot) authorized(user *tgbotapi.User, kind string) bool {
+	if user == nil {
+		log.Printf("Ignoring %s without a sender", kind)
+		return false
+	}
+	if !bot.UserAllowed(user.UserName) {
+		log.Printf("Unauthorized %s from user %s", kind, user.UserName)
+		return false
+	}
+	return true
+}
+
+func (bot *Bot) runUpdateHandler(kind string, handler func() error) {
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("Panic while handling %s: %v", kind, r)
+			}
+		}()
+		err := handler()
+		if err != nil {
+			log.Printf("Error while handling %s: %s", kind, err)
+		}
+	}()
+}
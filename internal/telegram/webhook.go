@@ -0,0 +1,136 @@
+package telegram
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/iley/lich/internal/config"
+)
+
+const (
+	secretTokenHeader = "X-Telegram-Bot-Api-Secret-Token"
+	// Updates are small JSON documents, anything larger is not from Telegram.
+	maxUpdateSize          = 1 << 20
+	webhookShutdownTimeout = 5 * time.Second
+)
+
+// runWebhook serves the updates Telegram pushes to the webhook until ctx is done. The webhook is removed on the way
+// out, so that Telegram keeps new updates until the next run, which may poll for them instead.
+func (bot *Bot) runWebhook(ctx context.Context, webhook *config.WebhookConfig) error {
+	hookURL, err := url.Parse(webhook.URL)
+	if err != nil {
+		return fmt.Errorf("invalid webhook URL: %w", err)
+	}
+	hookPath := hookURL.Path
+	if hookPath == "" {
+		hookPath = "/"
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(hookPath, bot.makeWebhookHandler(webhook.SecretToken))
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	// Listen before registering the webhook, so that Telegram does not get errors in between.
+	listener, err := net.Listen("tcp", webhook.ListenAddress)
+	if err != nil {
+		return fmt.Errorf("could not listen on %s: %w", webhook.ListenAddress, err)
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		if webhook.CertFile != "" {
+			serveErr <- server.ServeTLS(listener, webhook.CertFile, webhook.KeyFile)
+		} else {
+			serveErr <- server.Serve(listener)
+		}
+	}()
+
+	params := tgbotapi.Params{
+		"url":          webhook.URL,
+		"secret_token": webhook.SecretToken,
+	}
+	if webhook.CertFile != "" {
+		// Telegram only trusts self-signed certificates it was given. Uploading a CA-signed one does no harm.
+		certificate := tgbotapi.RequestFile{Name: "certificate", Data: tgbotapi.FilePath(webhook.CertFile)}
+		_, err = bot.api.UploadFiles("setWebhook", params, []tgbotapi.RequestFile{certificate})
+	} else {
+		_, err = bot.api.MakeRequest("setWebhook", params)
+	}
+	if err != nil {
+		server.Close()
+		return fmt.Errorf("could not register the webhook: %w", err)
+	}
+	log.Printf("Receiving updates on %s via webhook %s", webhook.ListenAddress, hookURL.Redacted())
+
+	select {
+	case <-ctx.Done():
+		log.Println("Termination signal received, shutting down the webhook")
+	case err = <-serveErr:
+		err = fmt.Errorf("webhook server failed: %w", err)
+	}
+
+	_, deleteErr := bot.api.Request(tgbotapi.DeleteWebhookConfig{})
+	if deleteErr != nil {
+		log.Printf("Could not remove the webhook: %s", deleteErr)
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), webhookShutdownTimeout)
+	defer cancel()
+	shutdownErr := server.Shutdown(shutdownCtx)
+	if shutdownErr != nil && !errors.Is(shutdownErr, http.ErrServerClosed) {
+		log.Printf("Could not shut down the webhook server: %s", shutdownErr)
+	}
+	return err
+}
+
+// makeWebhookHandler accepts updates that carry the secret token given to Telegram when registering the webhook.
+func (bot *Bot) makeWebhookHandler(secretToken string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		token := r.Header.Get(secretTokenHeader)
+		if subtle.ConstantTimeCompare([]byte(token), []byte(secretToken)) != 1 {
+			log.Printf("Rejecting webhook request from %s: wrong secret token", r.RemoteAddr)
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		var update tgbotapi.Update
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxUpdateSize)).Decode(&update)
+		if err != nil {
+			log.Printf("Could not decode update from webhook: %s", err)
+			http.Error(w, "malformed update", http.StatusBadRequest)
+			return
+		}
+		bot.dispatch(update)
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// removeStaleWebhook removes the webhook left by a run in webhook mode, which would make polling fail.
+func (bot *Bot) removeStaleWebhook() {
+	info, err := bot.api.GetWebhookInfo()
+	if err != nil {
+		log.Printf("Could not check for a webhook: %s", err)
+		return
+	}
+	if !info.IsSet() {
+		return
+	}
+	log.Printf("Removing webhook %s to poll for updates instead", info.URL)
+	_, err = bot.api.Request(tgbotapi.DeleteWebhookConfig{})
+	if err != nil {
+		log.Printf("Could not remove the webhook: %s", err)
+	}
+}
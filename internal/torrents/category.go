@@ -0,0 +1,115 @@
+package torrents
+
+import (
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/iley/lich/internal/config"
+)
+
+// SetCategory changes the category of a download whose files are not in the library yet.
+func (d *Downloader) SetCategory(torrentId string, category string) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if _, found := d.config.TargetDirs[category]; !found {
+		return fmt.Errorf("unknown category %s", category)
+	}
+	req, found := d.downloads[torrentId]
+	if !found {
+		return fmt.Errorf("torrent %s not found", torrentId)
+	}
+	if _, found := d.processing[torrentId]; found {
+		return fmt.Errorf("torrent %s is being moved into the library", torrentId)
+	}
+	if req.LibraryPath != "" {
+		return fmt.Errorf("torrent %s is already in the library, use /move_%s", torrentId, torrentId)
+	}
+	log.Printf("Changing category of torrent %s from %s to %s", torrentId, req.Category, category)
+	req.Category = category
+	d.persistRequest(req)
+	return nil
+}
+
+// Library returns the downloads placed into the library, most recent first.
+func (d *Downloader) Library() ([]*LibraryItem, error) {
+	items, err := d.store.LoadLibraryItems()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].PlacedAt.After(items[j].PlacedAt)
+	})
+	return items, nil
+}
+
+// MoveLibraryItem moves the placed files of a download into the target directory of another category,
+// arranging them the way that category would. Directories left empty in the old category are removed.
+// The progress of copying large items between file systems is shown in chatId unless it is zero.
+func (d *Downloader) MoveLibraryItem(torrentId string, category string, chatId int64) (*LibraryItem, error) {
+	if _, found := d.config.TargetDirs[category]; !found {
+		return nil, fmt.Errorf("unknown category %s", category)
+	}
+	item, err := d.store.LoadLibraryItem(torrentId)
+	if err != nil {
+		return nil, fmt.Errorf("could not load library item %s: %w", torrentId, err)
+	}
+	if item == nil {
+		return nil, fmt.Errorf("torrent %s is not in the library", torrentId)
+	}
+	if item.Category == category {
+		return nil, fmt.Errorf("torrent %s is already in %s", torrentId, category)
+	}
+	d.mutex.Lock()
+	_, busy := d.processing[torrentId]
+	d.mutex.Unlock()
+	if busy {
+		return nil, fmt.Errorf("torrent %s is being moved into the library", torrentId)
+	}
+
+	options := d.config.Category(category)
+	layout := LibraryLayout{
+		ContainerName: expandNameTemplate(options.NameTemplate, nameFields{
+			Name:     item.Name,
+			InfoHash: item.InfoHash,
+			Category: category,
+			Date:     item.PlacedAt,
+		}),
+		Series:      options.Layout == config.LayoutSeries,
+		ReleaseName: item.Name,
+	}
+	reporter := d.newMoveReporter(chatId, item.Name)
+	d.libraryMutex.Lock()
+	log.Printf("Moving library item %s from %s to %s", item.Name, item.Category, category)
+	moved, err := d.placeFiles(torrentId, item.Paths, d.GetTargetDir(category), layout, placementMove, &moveProgress{report: reporter.report})
+	if err == nil {
+		removeLeftovers(item.Paths, d.GetTargetDir(item.Category))
+	}
+	d.libraryMutex.Unlock()
+	reporter.finish(err)
+	if err != nil {
+		return nil, err
+	}
+	moved.Name = item.Name
+	moved.InfoHash = item.InfoHash
+	moved.Category = category
+	moved.PlacedAt = item.PlacedAt
+
+	d.mutex.Lock()
+	err = d.store.PutLibraryItem(moved)
+	if err != nil {
+		log.Printf("Could not record library item for torrent %s: %s", torrentId, err)
+	}
+	if req, found := d.downloads[torrentId]; found {
+		req.Category = category
+		req.LibraryPath = moved.Path
+		d.persistRequest(req)
+	}
+	d.mutex.Unlock()
+
+	if d.mediaServer != nil {
+		go d.mediaServer.RefreshWithRetries(d.ctx, category, scanPath(moved.Path))
+	}
+	return moved, nil
+}
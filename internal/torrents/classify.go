@@ -0,0 +1,117 @@
+package torrents
+
+import (
+	"path"
+	"strings"
+
+	"github.com/iley/lich/internal/config"
+)
+
+const (
+	ruleConfidence = 0.95
+	// Confidence when several categories have matching rules, or when only the name of a torrent is known.
+	weakConfidence = 0.5
+	// Several big video files without episode markers are probably a series anyway.
+	unmarkedEpisodesPenalty = 0.6
+	minUnmarkedEpisodes     = 3
+	minEpisodeSize          = 100 * 1024 * 1024
+)
+
+var (
+	videoExtensions = map[string]struct{}{
+		".avi": {}, ".m2ts": {}, ".m4v": {}, ".mkv": {}, ".mov": {}, ".mp4": {}, ".mpeg": {}, ".mpg": {},
+		".ts": {}, ".vob": {}, ".webm": {}, ".wmv": {},
+	}
+	audioExtensions = map[string]struct{}{
+		".aac": {}, ".ape": {}, ".flac": {}, ".m4a": {}, ".mp3": {}, ".ogg": {}, ".opus": {}, ".wav": {}, ".wv": {},
+	}
+)
+
+// Classification is the category suggested for a torrent.
+type Classification struct {
+	// Empty if there is no suggestion.
+	Category string
+	// Between 0 and 1.
+	Confidence float64
+	// Why the category was suggested, for the user.
+	Reason string
+}
+
+// Classify suggests a category for a torrent from its name and files.
+// Matching rules of categories come first, then the kind of files the torrent mostly consists of.
+func Classify(cfg *config.Config, preview *Preview) Classification {
+	matches := make([]string, 0)
+	for _, category := range cfg.Categories() {
+		for _, rule := range cfg.MatchRules(category) {
+			if rule.MatchString(preview.Name) {
+				matches = append(matches, category)
+				break
+			}
+		}
+	}
+	if len(matches) == 1 {
+		return Classification{Category: matches[0], Confidence: ruleConfidence, Reason: "name matches a rule"}
+	} else if len(matches) > 1 {
+		return Classification{Category: matches[0], Confidence: weakConfidence, Reason: "name matches several rules"}
+	}
+
+	classifier := cfg.Classifier
+	if classifier == nil {
+		return Classification{}
+	}
+	if len(preview.Contents) == 0 {
+		if classifier.SeriesCategory != "" && hasEpisodeMarker(preview.Name) {
+			return Classification{Category: classifier.SeriesCategory, Confidence: weakConfidence, Reason: "name looks like a series"}
+		}
+		return Classification{}
+	}
+
+	var total, video, audio int64
+	episodes := hasEpisodeMarker(preview.Name)
+	bigVideos := 0
+	for _, file := range preview.Contents {
+		total += file.Size
+		ext := strings.ToLower(path.Ext(file.Name))
+		if _, found := videoExtensions[ext]; found {
+			video += file.Size
+			if file.Size >= minEpisodeSize {
+				bigVideos++
+			}
+			if hasEpisodeMarker(path.Base(file.Name)) {
+				episodes = true
+			}
+		} else if _, found := audioExtensions[ext]; found {
+			audio += file.Size
+		}
+	}
+	if total == 0 {
+		return Classification{}
+	}
+	videoShare := float64(video) / float64(total)
+	audioShare := float64(audio) / float64(total)
+	switch {
+	case video > 0 && videoShare >= audioShare && episodes && classifier.SeriesCategory != "":
+		return Classification{Category: classifier.SeriesCategory, Confidence: videoShare, Reason: "video with episode markers"}
+	case video > 0 && videoShare >= audioShare && classifier.VideoCategory != "":
+		confidence := videoShare
+		if bigVideos >= minUnmarkedEpisodes {
+			confidence *= unmarkedEpisodesPenalty
+		}
+		return Classification{Category: classifier.VideoCategory, Confidence: confidence, Reason: "mostly video"}
+	case audio > 0 && classifier.AudioCategory != "":
+		return Classification{Category: classifier.AudioCategory, Confidence: audioShare, Reason: "mostly audio"}
+	}
+	return Classification{}
+}
+
+// AutoFile reports whether a torrent can be filed into the suggested category without asking the user.
+func AutoFile(cfg *config.Config, classification Classification) bool {
+	if cfg.Classifier == nil || cfg.Classifier.AutoFileConfidence == 0 || classification.Category == "" {
+		return false
+	}
+	return classification.Confidence >= cfg.Classifier.AutoFileConfidence
+}
+
+func hasEpisodeMarker(name string) bool {
+	return parseRelease(name).Season >= 0
+}
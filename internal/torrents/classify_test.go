@@ -0,0 +1,213 @@
+package torrents
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/iley/lich/internal/config"
+)
+
+// loadTestConfig writes cfg as config.json and loads it, so that it is validated and the match rules are compiled.
+func loadTestConfig(t *testing.T, cfg map[string]any) *config.Config {
+	t.Helper()
+	root := t.TempDir()
+	cfg["work_dir"] = filepath.Join(root, "work")
+	cfg["database_path"] = filepath.Join(root, "work", "rain.db")
+	targetDirs := make(map[string]string)
+	for _, category := range []string{"unsorted", "movies", "series", "music", "anime", "docs"} {
+		targetDirs[category] = filepath.Join(root, "library", category)
+	}
+	cfg["target_dirs"] = targetDirs
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configPath := filepath.Join(root, "config.json")
+	if err := os.WriteFile(configPath, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := config.LoadConfig(configPath)
+	if err != nil {
+		t.Fatalf("could not load test config: %s", err)
+	}
+	return loaded
+}
+
+func TestClassify(t *testing.T) {
+	const mb = 1024 * 1024
+	cfg := loadTestConfig(t, map[string]any{
+		"categories": map[string]any{
+			"anime": map[string]any{"match": []string{`\[SubsPlease\]`}},
+			"docs":  map[string]any{"match": []string{`documentary`, `\bBBC\b`}},
+			"music": map[string]any{"match": []string{`documentary`}},
+		},
+		"classifier": map[string]any{
+			"series_category": "series",
+			"video_category":  "movies",
+			"audio_category":  "music",
+		},
+	})
+	tests := []struct {
+		name           string
+		preview        Preview
+		wantCategory   string
+		wantConfidence float64
+	}{
+		{
+			name: "rule wins over files",
+			preview: Preview{Name: "[SubsPlease] Show - 01 (1080p)", Contents: []PreviewFile{
+				{Name: "Show S01E01.mkv", Size: 500 * mb},
+			}},
+			wantCategory:   "anime",
+			wantConfidence: ruleConfidence,
+		},
+		{
+			name:           "rules are case insensitive",
+			preview:        Preview{Name: "bbc planet earth"},
+			wantCategory:   "docs",
+			wantConfidence: ruleConfidence,
+		},
+		{
+			name:           "several rules match",
+			preview:        Preview{Name: "Nature Documentary"},
+			wantCategory:   "docs",
+			wantConfidence: weakConfidence,
+		},
+		{
+			name:           "name only with episode marker",
+			preview:        Preview{Name: "Show.Name.S02E03.1080p"},
+			wantCategory:   "series",
+			wantConfidence: weakConfidence,
+		},
+		{
+			name:    "name only without marker",
+			preview: Preview{Name: "Movie.Name.2019.1080p"},
+		},
+		{
+			name: "video with episode markers in file names",
+			preview: Preview{Name: "Show Name Complete", Contents: []PreviewFile{
+				{Name: "Show Name/Show.Name.S01E01.mkv", Size: 300 * mb},
+				{Name: "Show Name/Show.Name.S01E02.mkv", Size: 300 * mb},
+				{Name: "Show Name/cover.jpg", Size: 0},
+			}},
+			wantCategory:   "series",
+			wantConfidence: 1,
+		},
+		{
+			name: "video with episode marker in the torrent name",
+			preview: Preview{Name: "Show.Name.S01.1080p", Contents: []PreviewFile{
+				{Name: "Show.Name.S01/01.mkv", Size: 300 * mb},
+				{Name: "Show.Name.S01/info.nfo", Size: 100 * mb},
+			}},
+			wantCategory:   "series",
+			wantConfidence: 0.75,
+		},
+		{
+			name: "movie",
+			preview: Preview{Name: "Movie.Name.2019.1080p", Contents: []PreviewFile{
+				{Name: "Movie.Name.2019.1080p/movie.MKV", Size: 900 * mb},
+				{Name: "Movie.Name.2019.1080p/sample.txt", Size: 100 * mb},
+			}},
+			wantCategory:   "movies",
+			wantConfidence: 0.9,
+		},
+		{
+			name: "unmarked episodes are less likely movies",
+			preview: Preview{Name: "Some Show", Contents: []PreviewFile{
+				{Name: "Some Show/01.mkv", Size: 200 * mb},
+				{Name: "Some Show/02.mkv", Size: 200 * mb},
+				{Name: "Some Show/03.mkv", Size: 200 * mb},
+			}},
+			wantCategory:   "movies",
+			wantConfidence: unmarkedEpisodesPenalty,
+		},
+		{
+			name: "audio",
+			preview: Preview{Name: "Artist - Album (2001) [FLAC]", Contents: []PreviewFile{
+				{Name: "Album/01.flac", Size: 30 * mb},
+				{Name: "Album/02.flac", Size: 30 * mb},
+				{Name: "Album/cover.jpg", Size: 20 * mb},
+			}},
+			wantCategory:   "music",
+			wantConfidence: 0.75,
+		},
+		{
+			name: "video beats audio when it takes more space",
+			preview: Preview{Name: "Concert", Contents: []PreviewFile{
+				{Name: "Concert/concert.mp4", Size: 60 * mb},
+				{Name: "Concert/soundtrack.mp3", Size: 40 * mb},
+			}},
+			wantCategory:   "movies",
+			wantConfidence: 0.6,
+		},
+		{
+			name: "audio beats video when it takes more space",
+			preview: Preview{Name: "Album with clip", Contents: []PreviewFile{
+				{Name: "Album/clip.mp4", Size: 40 * mb},
+				{Name: "Album/album.flac", Size: 60 * mb},
+			}},
+			wantCategory:   "music",
+			wantConfidence: 0.6,
+		},
+		{
+			name: "neither video nor audio",
+			preview: Preview{Name: "Some Book", Contents: []PreviewFile{
+				{Name: "Some Book/book.epub", Size: 5 * mb},
+			}},
+		},
+		{
+			name: "empty files",
+			preview: Preview{Name: "Empty", Contents: []PreviewFile{
+				{Name: "Empty/movie.mkv", Size: 0},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Classify(cfg, &tt.preview)
+			if got.Category != tt.wantCategory || math.Abs(got.Confidence-tt.wantConfidence) > 1e-9 {
+				t.Errorf("Classify() = %q with confidence %f, want %q with confidence %f",
+					got.Category, got.Confidence, tt.wantCategory, tt.wantConfidence)
+			}
+		})
+	}
+}
+
+func TestClassifyWithoutClassifier(t *testing.T) {
+	cfg := loadTestConfig(t, map[string]any{})
+	preview := &Preview{Name: "Show.Name.S01E01", Contents: []PreviewFile{{Name: "Show.Name.S01E01.mkv", Size: 1 << 30}}}
+	if got := Classify(cfg, preview); got.Category != "" {
+		t.Errorf("Classify() = %q, want no suggestion without rules or classifier", got.Category)
+	}
+}
+
+func TestAutoFile(t *testing.T) {
+	cfg := loadTestConfig(t, map[string]any{
+		"classifier": map[string]any{"video_category": "movies", "auto_file_confidence": 0.8},
+	})
+	tests := []struct {
+		name           string
+		classification Classification
+		want           bool
+	}{
+		{"confident", Classification{Category: "movies", Confidence: 0.9}, true},
+		{"at threshold", Classification{Category: "movies", Confidence: 0.8}, true},
+		{"unsure", Classification{Category: "movies", Confidence: 0.7}, false},
+		{"no suggestion", Classification{Confidence: 1}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := AutoFile(cfg, tt.classification); got != tt.want {
+				t.Errorf("AutoFile() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	cfg.Classifier.AutoFileConfidence = 0
+	if AutoFile(cfg, Classification{Category: "movies", Confidence: 1}) {
+		t.Error("AutoFile() = true with auto-filing disabled")
+	}
+}
@@ -0,0 +1,412 @@
+package torrents
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	archiveZip   = "zip"
+	archiveTar   = "tar"
+	archiveTarGz = "tar.gz"
+)
+
+// archive is an archive found among the placed files. Split archives consist of several parts.
+type archive struct {
+	kind  string
+	parts []string
+	// File name without the archive and split extensions.
+	baseName string
+}
+
+// extractArchives unpacks the archives among the placed files of item. Archives inside a directory are extracted
+// next to themselves, an archive placed on its own is extracted into a new directory, which replaces the archive
+// in item if the archive is deleted and is added to item otherwise.
+func (d *Downloader) extractArchives(item *LibraryItem, deleteArchives bool) error {
+	d.libraryMutex.Lock()
+	defer d.libraryMutex.Unlock()
+
+	errs := make([]error, 0)
+	for i := range item.Paths {
+		err := d.extractArchivesAt(item, i, deleteArchives)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// extractArchivesAt extracts the archives found under the i-th placed path of item.
+// Must be called under d.libraryMutex.
+func (d *Downloader) extractArchivesAt(item *LibraryItem, i int, deleteArchives bool) error {
+	placedPath := item.Paths[i]
+	archives, err := findArchives(placedPath)
+	if err != nil {
+		return fmt.Errorf("could not look for archives: %w", err)
+	}
+	errs := make([]error, 0)
+	for _, a := range archives {
+		dest := path.Dir(a.parts[0])
+		lone := a.parts[0] == placedPath
+		if lone {
+			dest, err = d.SafeMkdir(dest, a.baseName)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("could not create directory for %s: %w", path.Base(a.parts[0]), err))
+				continue
+			}
+		}
+		log.Printf("Extracting %s into %s", a.parts[0], dest)
+		err = extractArchive(a, dest)
+		if err != nil {
+			if lone {
+				os.Remove(dest)
+			}
+			errs = append(errs, fmt.Errorf("could not extract %s: %w", path.Base(a.parts[0]), err))
+			continue
+		}
+		if lone && item.Path == placedPath {
+			// Hooks and the media server are interested in the extracted files rather than the archive.
+			item.Path = dest
+		}
+		if !deleteArchives {
+			if lone {
+				// Moved and forgotten along with the archive.
+				item.Paths = append(item.Paths, dest)
+			}
+			continue
+		}
+		for _, part := range a.parts {
+			err = os.Remove(part)
+			if err != nil {
+				log.Printf("Could not delete archive %s: %s", part, err)
+			}
+		}
+		if lone {
+			item.Paths[i] = dest
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// findArchives lists the archives under root, which may be a single file.
+// Only the first part of a split archive (name.zip.001) is reported, with the other parts attached.
+func findArchives(root string) ([]archive, error) {
+	archives := make([]archive, 0)
+	err := filepath.WalkDir(root, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		name := entry.Name()
+		split := strings.HasSuffix(name, ".001")
+		if split {
+			name = strings.TrimSuffix(name, ".001")
+		}
+		kind, baseName := archiveKind(name)
+		if kind == "" {
+			return nil
+		}
+		parts := []string{filePath}
+		if split {
+			parts = splitParts(strings.TrimSuffix(filePath, ".001"))
+		}
+		archives = append(archives, archive{kind: kind, parts: parts, baseName: baseName})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return archives, nil
+}
+
+// archiveKind recognizes archives by their extension. Returns an empty kind for other files.
+func archiveKind(name string) (string, string) {
+	lower := strings.ToLower(name)
+	for _, ext := range []struct{ suffix, kind string }{
+		{".tar.gz", archiveTarGz},
+		{".tgz", archiveTarGz},
+		{".tar", archiveTar},
+		{".zip", archiveZip},
+	} {
+		if strings.HasSuffix(lower, ext.suffix) && len(name) > len(ext.suffix) {
+			return ext.kind, name[:len(name)-len(ext.suffix)]
+		}
+	}
+	return "", ""
+}
+
+// splitParts returns base.001, base.002 and so on for as long as the parts exist.
+func splitParts(base string) []string {
+	parts := make([]string, 0)
+	for index := 1; ; index++ {
+		part := fmt.Sprintf("%s.%03d", base, index)
+		if _, err := os.Stat(part); err != nil {
+			return parts
+		}
+		parts = append(parts, part)
+	}
+}
+
+// extractArchive unpacks a into dest. Files that exist already get a suffix like in NewPath.
+// If extraction fails, everything extracted so far is removed.
+func extractArchive(a archive, dest string) error {
+	file, err := openSplitFile(a.parts)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	x := &extraction{dest: dest}
+	switch a.kind {
+	case archiveZip:
+		err = x.extractZip(file)
+	case archiveTar:
+		err = x.extractTar(io.NewSectionReader(file, 0, file.size))
+	case archiveTarGz:
+		var gz *gzip.Reader
+		gz, err = gzip.NewReader(io.NewSectionReader(file, 0, file.size))
+		if err == nil {
+			err = x.extractTar(gz)
+		}
+	}
+	if err != nil {
+		x.rollBack()
+	}
+	return err
+}
+
+// extraction keeps track of the files and directories created while extracting an archive.
+type extraction struct {
+	dest    string
+	created []string
+}
+
+func (x *extraction) extractZip(file *splitFile) error {
+	reader, err := zip.NewReader(file, file.size)
+	if err != nil {
+		return err
+	}
+	for _, entry := range reader.File {
+		mode := entry.Mode()
+		switch {
+		case mode.IsDir():
+			err = x.mkdir(entry.Name)
+		case mode.IsRegular():
+			err = x.extractZipFile(entry)
+		default:
+			log.Printf("Skipping %s in archive: not a regular file", entry.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (x *extraction) extractZipFile(entry *zip.File) error {
+	reader, err := entry.Open()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	return x.writeFile(entry.Name, reader, entry.Mode())
+}
+
+func (x *extraction) extractTar(r io.Reader) error {
+	reader := tar.NewReader(r)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = x.mkdir(header.Name)
+		case tar.TypeReg:
+			err = x.writeFile(header.Name, reader, header.FileInfo().Mode())
+		default:
+			// Links could point outside of dest, devices and pipes have no business in a library.
+			log.Printf("Skipping %s in archive: not a regular file", header.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// targetPath turns the name of an archive entry into a path under x.dest, refusing names that escape it.
+func (x *extraction) targetPath(name string) (string, error) {
+	name = strings.ReplaceAll(name, `\`, "/")
+	if path.IsAbs(name) {
+		return "", fmt.Errorf("archive entry %s has an absolute path", name)
+	}
+	for _, component := range strings.Split(name, "/") {
+		if component == ".." {
+			return "", fmt.Errorf("archive entry %s points outside of the archive", name)
+		}
+	}
+	target := filepath.Join(x.dest, filepath.FromSlash(name))
+	relPath, err := filepath.Rel(x.dest, target)
+	if err != nil || relPath == ".." || strings.HasPrefix(relPath, "../") {
+		return "", fmt.Errorf("archive entry %s points outside of the archive", name)
+	}
+	return target, nil
+}
+
+// mkdir creates the directory for an archive entry along with its parents.
+// Existing symlinks are not followed, so that an archive cannot write outside of x.dest through them.
+func (x *extraction) mkdir(name string) error {
+	target, err := x.targetPath(name)
+	if err != nil {
+		return err
+	}
+	return x.mkdirAll(target)
+}
+
+func (x *extraction) mkdirAll(target string) error {
+	relPath, err := filepath.Rel(x.dest, target)
+	if err != nil || relPath == "." {
+		return err
+	}
+	dir := x.dest
+	for _, component := range strings.Split(relPath, string(filepath.Separator)) {
+		dir = filepath.Join(dir, component)
+		info, err := os.Lstat(dir)
+		if os.IsNotExist(err) {
+			err = os.Mkdir(dir, 0o755)
+			if err != nil {
+				return err
+			}
+			x.created = append(x.created, dir)
+			continue
+		} else if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s exists and is not a directory", dir)
+		}
+	}
+	return nil
+}
+
+func (x *extraction) writeFile(name string, r io.Reader, mode fs.FileMode) error {
+	target, err := x.targetPath(name)
+	if err != nil {
+		return err
+	}
+	if target == x.dest {
+		return fmt.Errorf("archive entry %s has no name", name)
+	}
+	err = x.mkdirAll(filepath.Dir(target))
+	if err != nil {
+		return err
+	}
+	target, err = newPath(filepath.Dir(target), filepath.Base(target), nil)
+	if err != nil {
+		return err
+	}
+	perm := fs.FileMode(0o644)
+	if mode&0o111 != 0 {
+		perm = 0o755
+	}
+	file, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	x.created = append(x.created, target)
+	_, err = io.Copy(file, r)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// rollBack removes everything created by the extraction, newest first.
+func (x *extraction) rollBack() {
+	for i := len(x.created) - 1; i >= 0; i-- {
+		err := os.Remove(x.created[i])
+		if err != nil {
+			log.Printf("Could not remove partially extracted %s: %s", x.created[i], err)
+		}
+	}
+}
+
+// splitFile presents the parts of a split archive as a single file.
+type splitFile struct {
+	files []*os.File
+	sizes []int64
+	size  int64
+}
+
+func openSplitFile(parts []string) (*splitFile, error) {
+	f := &splitFile{}
+	for _, part := range parts {
+		file, err := os.Open(part)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		f.files = append(f.files, file)
+		info, err := file.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		f.sizes = append(f.sizes, info.Size())
+		f.size += info.Size()
+	}
+	return f, nil
+}
+
+func (f *splitFile) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for i, file := range f.files {
+		if len(p) == 0 {
+			break
+		}
+		if off >= f.sizes[i] {
+			off -= f.sizes[i]
+			continue
+		}
+		chunk := p
+		if remaining := f.sizes[i] - off; int64(len(chunk)) > remaining {
+			chunk = chunk[:remaining]
+		}
+		read, err := file.ReadAt(chunk, off)
+		n += read
+		if err != nil && err != io.EOF {
+			return n, err
+		}
+		if read < len(chunk) {
+			return n, io.ErrUnexpectedEOF
+		}
+		p = p[read:]
+		off = 0
+	}
+	if len(p) > 0 {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *splitFile) Close() error {
+	for _, file := range f.files {
+		file.Close()
+	}
+	return nil
+}
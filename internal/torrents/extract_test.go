@@ -0,0 +1,523 @@
+package torrents
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+type fixtureEntry struct {
+	name    string
+	content string
+	dir     bool
+}
+
+func zipFixture(t *testing.T, entries []fixtureEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	for _, entry := range entries {
+		header := &zip.FileHeader{Name: entry.name, Method: zip.Store}
+		if entry.dir {
+			header.SetMode(os.ModeDir | 0o755)
+		} else {
+			header.SetMode(0o644)
+		}
+		w, err := writer.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(entry.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func tarFixture(t *testing.T, entries []fixtureEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	writer := tar.NewWriter(&buf)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(entry.content))}
+		if entry.dir {
+			header = &tar.Header{Name: entry.name, Typeflag: tar.TypeDir, Mode: 0o755}
+		}
+		if err := writer.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := writer.Write([]byte(entry.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func gzipFixture(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// writeParts writes data to base, or to base.001, base.002 and so on if split gives the sizes of the parts
+// except the last one. Returns the written files.
+func writeParts(t *testing.T, base string, data []byte, split []int) []string {
+	t.Helper()
+	if len(split) == 0 {
+		if err := os.WriteFile(base, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return []string{base}
+	}
+	parts := make([]string, 0)
+	for i := 0; i <= len(split); i++ {
+		chunk := data
+		if i < len(split) {
+			chunk = data[:split[i]]
+		}
+		data = data[len(chunk):]
+		part := fmt.Sprintf("%s.%03d", base, i+1)
+		if err := os.WriteFile(part, chunk, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		parts = append(parts, part)
+	}
+	return parts
+}
+
+// listTree returns the paths under root relative to it, symlinks are not followed.
+func listTree(t *testing.T, root string) []string {
+	t.Helper()
+	paths := make([]string, 0)
+	err := filepath.Walk(root, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if filePath != root {
+			relPath, _ := filepath.Rel(root, filePath)
+			paths = append(paths, filepath.ToSlash(relPath))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func TestExtractArchive(t *testing.T) {
+	tests := []struct {
+		name    string
+		kind    string
+		entries []fixtureEntry
+		// Cuts the archive to this many bytes if not zero.
+		truncate int
+		// Splits the archive into name.001, name.002 and so on with these sizes, the last part takes the rest.
+		split []int
+		// Makes dest/link point to a directory outside of dest.
+		symlink bool
+		wantErr bool
+		// Paths under dest after the extraction.
+		want []string
+	}{
+		{
+			name:    "zip",
+			kind:    archiveZip,
+			entries: []fixtureEntry{{name: "dir/", dir: true}, {name: "dir/a.txt", content: "a"}, {name: "b.txt", content: "b"}},
+			want:    []string{"b.txt", "dir", "dir/a.txt"},
+		},
+		{
+			name:    "tar",
+			kind:    archiveTar,
+			entries: []fixtureEntry{{name: "dir/a.txt", content: "a"}},
+			want:    []string{"dir", "dir/a.txt"},
+		},
+		{
+			name:    "zip parent directory",
+			kind:    archiveZip,
+			entries: []fixtureEntry{{name: "a.txt", content: "a"}, {name: "../evil.txt", content: "evil"}},
+			wantErr: true,
+		},
+		{
+			name:    "tar parent directory in the middle",
+			kind:    archiveTar,
+			entries: []fixtureEntry{{name: "dir/a.txt", content: "a"}, {name: "dir/../../evil.txt", content: "evil"}},
+			wantErr: true,
+		},
+		{
+			name:    "zip absolute path",
+			kind:    archiveZip,
+			entries: []fixtureEntry{{name: "/tmp/evil.txt", content: "evil"}},
+			wantErr: true,
+		},
+		{
+			name:    "tar absolute path",
+			kind:    archiveTar,
+			entries: []fixtureEntry{{name: "/tmp/evil.txt", content: "evil"}},
+			wantErr: true,
+		},
+		{
+			name:    "zip backslash parent directory",
+			kind:    archiveZip,
+			entries: []fixtureEntry{{name: `..\evil.txt`, content: "evil"}},
+			wantErr: true,
+		},
+		{
+			name:    "tar backslash absolute path",
+			kind:    archiveTar,
+			entries: []fixtureEntry{{name: `\tmp\evil.txt`, content: "evil"}},
+			wantErr: true,
+		},
+		{
+			name:    "zip backslash separators",
+			kind:    archiveZip,
+			entries: []fixtureEntry{{name: `dir\a.txt`, content: "a"}},
+			want:    []string{"dir", "dir/a.txt"},
+		},
+		{
+			name:    "zip through symlinked directory",
+			kind:    archiveZip,
+			entries: []fixtureEntry{{name: "link/evil.txt", content: "evil"}},
+			symlink: true,
+			wantErr: true,
+			want:    []string{"link"},
+		},
+		{
+			name:    "tar directory through symlinked directory",
+			kind:    archiveTar,
+			entries: []fixtureEntry{{name: "link/sub/", dir: true}},
+			symlink: true,
+			wantErr: true,
+			want:    []string{"link"},
+		},
+		{
+			name:    "split zip",
+			kind:    archiveZip,
+			entries: []fixtureEntry{{name: "dir/a.txt", content: "a"}, {name: "b.txt", content: "b"}},
+			// The short middle part makes reads span three parts.
+			split: []int{40, 3},
+			want:  []string{"b.txt", "dir", "dir/a.txt"},
+		},
+		{
+			name:    "split tar",
+			kind:    archiveTar,
+			entries: []fixtureEntry{{name: "a.txt", content: string(bytes.Repeat([]byte("a"), 1000))}},
+			split:   []int{700, 1, 100},
+			want:    []string{"a.txt"},
+		},
+		{
+			name:    "tar.gz",
+			kind:    archiveTarGz,
+			entries: []fixtureEntry{{name: "dir/", dir: true}, {name: "dir/a.txt", content: "a"}},
+			want:    []string{"dir", "dir/a.txt"},
+		},
+		{
+			name:    "split tar.gz",
+			kind:    archiveTarGz,
+			entries: []fixtureEntry{{name: "a.txt", content: "a"}},
+			split:   []int{10, 10},
+			want:    []string{"a.txt"},
+		},
+		{
+			name:    "tar.gz parent directory",
+			kind:    archiveTarGz,
+			entries: []fixtureEntry{{name: "../evil.txt", content: "evil"}},
+			wantErr: true,
+		},
+		{
+			name: "truncated tar",
+			kind: archiveTar,
+			entries: []fixtureEntry{
+				{name: "dir/a.txt", content: "a"},
+				{name: "dir/sub/b.txt", content: string(bytes.Repeat([]byte("b"), 4096))},
+			},
+			// Headers and data take 512-byte blocks: a.txt and its data, then b.txt and half of its data.
+			truncate: 512*3 + 2048,
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			dest := filepath.Join(root, "dest")
+			outside := filepath.Join(root, "outside")
+			for _, dir := range []string{dest, outside} {
+				if err := os.Mkdir(dir, 0o755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.symlink {
+				if err := os.Symlink(outside, filepath.Join(dest, "link")); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var data []byte
+			switch tt.kind {
+			case archiveZip:
+				data = zipFixture(t, tt.entries)
+			case archiveTar:
+				data = tarFixture(t, tt.entries)
+			case archiveTarGz:
+				data = gzipFixture(t, tarFixture(t, tt.entries))
+			}
+			if tt.truncate != 0 {
+				data = data[:tt.truncate]
+			}
+			parts := writeParts(t, filepath.Join(root, "archive."+tt.kind), data, tt.split)
+
+			err := extractArchive(archive{kind: tt.kind, parts: parts}, dest)
+			if tt.wantErr && err == nil {
+				t.Fatal("extraction succeeded, want an error")
+			} else if !tt.wantErr && err != nil {
+				t.Fatalf("extraction failed: %s", err)
+			}
+
+			want := tt.want
+			if want == nil {
+				want = []string{}
+			}
+			got := listTree(t, dest)
+			if len(got) != len(want) {
+				t.Fatalf("dest contains %v, want %v", got, want)
+			}
+			for i := range got {
+				if got[i] != want[i] {
+					t.Fatalf("dest contains %v, want %v", got, want)
+				}
+			}
+			if leaked := listTree(t, outside); len(leaked) != 0 {
+				t.Fatalf("extraction wrote %v outside of dest", leaked)
+			}
+			if siblings, _ := os.ReadDir(root); len(siblings) != 2+len(parts) {
+				t.Fatalf("extraction wrote next to dest, found %d entries", len(siblings))
+			}
+		})
+	}
+}
+
+func TestExtractArchives(t *testing.T) {
+	tests := []struct {
+		name           string
+		deleteArchives bool
+		// Places the archive inside a directory instead of on its own.
+		inDir     bool
+		wantPath  string
+		wantPaths []string
+		// Paths under the category directory after the extraction.
+		want []string
+	}{
+		{
+			name:           "lone archive deleted",
+			deleteArchives: true,
+			wantPath:       "film",
+			wantPaths:      []string{"film"},
+			want:           []string{"film", "film/film.mkv"},
+		},
+		{
+			name:      "lone archive kept",
+			wantPath:  "film",
+			wantPaths: []string{"film.zip", "film"},
+			want:      []string{"film", "film.zip", "film/film.mkv"},
+		},
+		{
+			name:      "archive in directory kept",
+			inDir:     true,
+			wantPath:  "release",
+			wantPaths: []string{"release"},
+			want:      []string{"release", "release/film.mkv", "release/film.zip"},
+		},
+		{
+			name:           "archive in directory deleted",
+			deleteArchives: true,
+			inDir:          true,
+			wantPath:       "release",
+			wantPaths:      []string{"release"},
+			want:           []string{"release", "release/film.mkv"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			placed := filepath.Join(root, "film.zip")
+			archivePath := placed
+			if tt.inDir {
+				placed = filepath.Join(root, "release")
+				archivePath = filepath.Join(placed, "film.zip")
+				if err := os.Mkdir(placed, 0o755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			data := zipFixture(t, []fixtureEntry{{name: "film.mkv", content: "film"}})
+			if err := os.WriteFile(archivePath, data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			d := &Downloader{}
+			item := &LibraryItem{Path: placed, Paths: []string{placed}}
+			err := d.extractArchives(item, tt.deleteArchives)
+			if err != nil {
+				t.Fatalf("extraction failed: %s", err)
+			}
+
+			if want := filepath.Join(root, tt.wantPath); item.Path != want {
+				t.Errorf("item path is %s, want %s", item.Path, want)
+			}
+			if len(item.Paths) != len(tt.wantPaths) {
+				t.Fatalf("item paths are %v, want %v", item.Paths, tt.wantPaths)
+			}
+			for i, want := range tt.wantPaths {
+				if want := filepath.Join(root, want); item.Paths[i] != want {
+					t.Errorf("item paths are %v, want %v", item.Paths, tt.wantPaths)
+				}
+			}
+			got := listTree(t, root)
+			if len(got) != len(tt.want) {
+				t.Fatalf("library contains %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("library contains %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestSplitFileReadAt(t *testing.T) {
+	data := []byte("0123456789abcdefghij")
+	root := t.TempDir()
+	// A one-byte part in the middle.
+	parts := writeParts(t, filepath.Join(root, "data"), data, []int{8, 1, 5})
+	file, err := openSplitFile(parts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if file.size != int64(len(data)) {
+		t.Fatalf("size is %d, want %d", file.size, len(data))
+	}
+
+	tests := []struct {
+		name    string
+		off     int64
+		length  int
+		wantErr error
+	}{
+		{"first part", 0, 8, nil},
+		{"into short part", 6, 3, nil},
+		{"across short part", 7, 3, nil},
+		{"short part only", 8, 1, nil},
+		{"all parts", 0, 20, nil},
+		{"last part", 14, 6, nil},
+		{"past end", 18, 4, io.EOF},
+		{"at end", 20, 1, io.EOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := make([]byte, tt.length)
+			n, err := file.ReadAt(buf, tt.off)
+			if err != tt.wantErr {
+				t.Fatalf("ReadAt returned error %v, want %v", err, tt.wantErr)
+			}
+			want := data[min(int(tt.off), len(data)):min(int(tt.off)+tt.length, len(data))]
+			if !bytes.Equal(buf[:n], want) {
+				t.Errorf("ReadAt read %q, want %q", buf[:n], want)
+			}
+		})
+	}
+}
+
+func TestFindArchives(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{
+		"film.zip.001", "film.zip.002", "film.zip.003",
+		"extras/bonus.tar.gz",
+		"extras/music.tgz",
+		// Later parts are not reported on their own, even without the first one.
+		"orphan.zip.002",
+		"notes.txt",
+		".zip",
+	} {
+		filePath := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(filePath), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filePath, []byte("data"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	archives, err := findArchives(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []archive{
+		{kind: archiveTarGz, baseName: "bonus", parts: []string{"extras/bonus.tar.gz"}},
+		{kind: archiveTarGz, baseName: "music", parts: []string{"extras/music.tgz"}},
+		{kind: archiveZip, baseName: "film", parts: []string{"film.zip.001", "film.zip.002", "film.zip.003"}},
+	}
+	if len(archives) != len(want) {
+		t.Fatalf("found %+v, want %+v", archives, want)
+	}
+	for i, a := range archives {
+		w := want[i]
+		if a.kind != w.kind || a.baseName != w.baseName || len(a.parts) != len(w.parts) {
+			t.Fatalf("found %+v, want %+v", a, w)
+		}
+		for j, part := range a.parts {
+			if part != filepath.Join(root, w.parts[j]) {
+				t.Errorf("archive %s has parts %v, want %v", a.baseName, a.parts, w.parts)
+			}
+		}
+	}
+}
+
+func TestArchiveKind(t *testing.T) {
+	tests := []struct {
+		name         string
+		wantKind     string
+		wantBaseName string
+	}{
+		{"film.zip", archiveZip, "film"},
+		{"Film.ZIP", archiveZip, "Film"},
+		{"music.tar", archiveTar, "music"},
+		{"music.tar.gz", archiveTarGz, "music"},
+		{"music.tgz", archiveTarGz, "music"},
+		{".zip", "", ""},
+		{".tar.gz", "", ""},
+		{"film.mkv", "", ""},
+		{"zip", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kind, baseName := archiveKind(tt.name)
+			if kind != tt.wantKind || baseName != tt.wantBaseName {
+				t.Errorf("archiveKind(%q) = %q, %q, want %q, %q", tt.name, kind, baseName, tt.wantKind, tt.wantBaseName)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package torrents
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/cenkalti/rain/torrent"
+)
+
+// fail records the failure of a torrent and returns the notices for the user.
+// Must be called under d.mutex, the notices are sent with sendNotices after releasing it.
+func (d *Downloader) fail(torr *torrent.Torrent, req *DownloadRequest, err error) []notice {
+	log.Printf("Torrent %s failed: %s", torr.Name(), err)
+	req.Error = err.Error()
+	d.persistRequest(req)
+	// Failed torrents do not take a download slot.
+	d.startQueued()
+	if req.ChatId == 0 {
+		return nil
+	}
+	notices := d.finishStatusMessage(req, fmt.Sprintf("Download of [%s] %s failed", req.Category, torr.Name()))
+	text := fmt.Sprintf("Download of [%s] %s failed: %s\n/retry_%s /cancel_%s",
+		req.Category, torr.Name(), err, torr.ID(), torr.ID())
+	return append(notices, notice{chatId: req.ChatId, text: text})
+}
+
+// Retry restarts a failed torrent. The torrent is queued if there are no free download slots.
+func (d *Downloader) Retry(torrentId string) error {
+	chatId, name, err := d.retry(torrentId)
+	if err != nil {
+		return err
+	}
+	d.attachStatusMessage(torrentId, chatId, "Retrying download of "+name)
+	return nil
+}
+
+// retry returns the chat and the name of the retried download.
+func (d *Downloader) retry(torrentId string) (int64, string, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	req, found := d.downloads[torrentId]
+	torr := d.session.GetTorrent(torrentId)
+	if !found || torr == nil {
+		return 0, "", fmt.Errorf("torrent %s not found", torrentId)
+	}
+	if req.Error == "" {
+		return 0, "", fmt.Errorf("torrent %s has not failed", torrentId)
+	}
+
+	req.Error = ""
+	if !d.hasFreeSlot() {
+		log.Printf("Queueing retried torrent %s", torr.Name())
+		d.queue = append(d.queue, torrentId)
+		d.persistQueue()
+		d.persistRequest(req)
+		return req.ChatId, req.ToString(), nil
+	}
+
+	log.Printf("Retrying torrent %s", torr.Name())
+	err := torr.Start()
+	if err != nil {
+		req.Error = err.Error()
+		d.persistRequest(req)
+		return 0, "", fmt.Errorf("could not start torrent %s: %w", torrentId, err)
+	}
+	d.watch(torr)
+	d.persistRequest(req)
+	return req.ChatId, req.ToString(), nil
+}
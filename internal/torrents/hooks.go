@@ -0,0 +1,109 @@
+package torrents
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"time"
+
+	"github.com/iley/lich/internal/config"
+)
+
+// How long to wait for the output of a hook after it is killed, in case it left children holding the pipes.
+const hookWaitDelay = 5 * time.Second
+
+// hookEnv describes a placed download to on_complete hooks.
+type hookEnv struct {
+	Path     string
+	Category string
+	Name     string
+	InfoHash string
+	User     string
+	ChatId   int64
+}
+
+func (env hookEnv) environ() []string {
+	return append(os.Environ(),
+		"LICH_PATH="+env.Path,
+		"LICH_CATEGORY="+env.Category,
+		"LICH_NAME="+env.Name,
+		"LICH_INFOHASH="+env.InfoHash,
+		"LICH_USER="+env.User,
+	)
+}
+
+// runHooks runs the on_complete hooks of a category one after another.
+// It is meant to run in its own goroutine so that slow hooks do not hold up post-processing.
+func (d *Downloader) runHooks(hooks []*config.HookConfig, env hookEnv) {
+	for _, hook := range hooks {
+		err := runHook(hook, env)
+		name := path.Base(hook.Command[0])
+		if err != nil {
+			log.Printf("Hook %s for torrent %s failed: %s", name, env.Name, err)
+		} else {
+			log.Printf("Hook %s for torrent %s finished", name, env.Name)
+		}
+		if !hook.Notify || env.ChatId == 0 {
+			continue
+		}
+		if err != nil {
+			d.messenger.SendReply(env.ChatId, fmt.Sprintf("Hook %s for [%s] %s failed: %s", name, env.Category, env.Name, err))
+		} else {
+			d.messenger.SendReply(env.ChatId, fmt.Sprintf("Hook %s for [%s] %s finished", name, env.Category, env.Name))
+		}
+	}
+}
+
+func runHook(hook *config.HookConfig, env hookEnv) error {
+	ctx, cancel := context.WithTimeout(context.Background(), hook.TimeoutDuration())
+	defer cancel()
+
+	name := path.Base(hook.Command[0])
+	stdout := &lineLogger{prefix: name + " stdout"}
+	stderr := &lineLogger{prefix: name + " stderr"}
+	cmd := exec.CommandContext(ctx, hook.Command[0], hook.Command[1:]...)
+	cmd.Env = env.environ()
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.WaitDelay = hookWaitDelay
+
+	log.Printf("Running hook %v for torrent %s", hook.Command, env.Name)
+	err := cmd.Run()
+	stdout.flush()
+	stderr.flush()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("timed out after %s", hook.TimeoutDuration())
+	}
+	return err
+}
+
+// lineLogger writes the output of a command to the log line by line.
+type lineLogger struct {
+	prefix string
+	buf    []byte
+}
+
+func (l *lineLogger) Write(p []byte) (int, error) {
+	l.buf = append(l.buf, p...)
+	for {
+		end := bytes.IndexByte(l.buf, '\n')
+		if end < 0 {
+			break
+		}
+		log.Printf("%s: %s", l.prefix, l.buf[:end])
+		l.buf = l.buf[end+1:]
+	}
+	return len(p), nil
+}
+
+func (l *lineLogger) flush() {
+	if len(l.buf) > 0 {
+		log.Printf("%s: %s", l.prefix, l.buf)
+		l.buf = nil
+	}
+}
@@ -0,0 +1,191 @@
+package torrents
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"syscall"
+)
+
+const (
+	placementMove = "move"
+	placementLink = "link"
+	placementCopy = "copy"
+)
+
+// journalEntry describes how the files of a torrent are being placed into the library.
+// It is stored before the files are touched and deleted once they are placed, so that a placement
+// interrupted by a crash can be rolled back on the next start.
+type journalEntry struct {
+	TorrentId string `json:"torrent_id"`
+	Mode      string `json:"mode"`
+	// Directory created to hold the files of a multi-file torrent, after Directories. Empty if there is none.
+	Container string `json:"container,omitempty"`
+	// Directories that did not exist when the placement was planned, parents first.
+	// They are created before any files are placed and removed on rollback if they are empty.
+	Directories []string    `json:"directories,omitempty"`
+	Placements  []placement `json:"placements"`
+}
+
+type placement struct {
+	Src  string `json:"src"`
+	Dest string `json:"dest"`
+	// Set once a move between file systems has copied and verified the files, right before the source is removed.
+	// From then on the destination is the complete copy and the source may be partially removed.
+	Copied bool `json:"copied,omitempty"`
+}
+
+// runJournal places the files described by entry. If anything fails, the placed files are rolled back.
+func (d *Downloader) runJournal(entry *journalEntry, progress *moveProgress) error {
+	err := d.store.PutJournal(entry)
+	if err != nil {
+		return fmt.Errorf("could not record planned placement: %w", err)
+	}
+	for _, dir := range entry.Directories {
+		err = os.Mkdir(dir, 0o755)
+		if err != nil && !os.IsExist(err) {
+			break
+		}
+		err = nil
+	}
+	if err == nil && entry.Container != "" {
+		err = os.Mkdir(entry.Container, 0o755)
+	}
+	for i := range entry.Placements {
+		if err != nil {
+			break
+		}
+		p := &entry.Placements[i]
+		switch entry.Mode {
+		case placementMove:
+			log.Printf("Moving %s to %s", p.Src, p.Dest)
+			err = moveTree(p.Src, p.Dest, progress, func() error {
+				return markCopied(entry, p, true, d.store.PutJournal)
+			})
+		case placementLink:
+			log.Printf("Linking %s to %s", p.Src, p.Dest)
+			err = linkTree(p.Src, p.Dest)
+		case placementCopy:
+			log.Printf("Copying %s to %s", p.Src, p.Dest)
+			err = copyTree(p.Src, p.Dest, progress)
+		default:
+			err = fmt.Errorf("unknown placement mode %s", entry.Mode)
+		}
+	}
+	if err != nil {
+		rollbackErr := rollBack(entry, d.store.PutJournal)
+		if rollbackErr != nil {
+			log.Printf("Could not roll back placement of torrent %s: %s", entry.TorrentId, rollbackErr)
+			// Keep the journal so that the next start tries again.
+			return err
+		}
+	}
+	journalErr := d.store.DeleteJournal(entry.TorrentId)
+	if journalErr != nil {
+		log.Printf("Could not delete journal entry for torrent %s: %s", entry.TorrentId, journalErr)
+	}
+	return err
+}
+
+// markCopied records whether the destination of p holds the only complete copy of its files.
+func markCopied(entry *journalEntry, p *placement, copied bool, save func(*journalEntry) error) error {
+	p.Copied = copied
+	err := save(entry)
+	if err != nil {
+		p.Copied = !copied
+		return fmt.Errorf("could not record copied files: %w", err)
+	}
+	return nil
+}
+
+// rollBack returns the files of an unfinished placement to the work directory. Changes to entry are saved with save.
+// Sources are only removed after a complete, verified copy, which is recorded in Copied first. So unless Copied is set,
+// whenever a source still exists the destination is either a partial copy or a link and can be removed.
+func rollBack(entry *journalEntry, save func(*journalEntry) error) error {
+	for i := range entry.Placements {
+		p := &entry.Placements[i]
+		if p.Copied {
+			// The move was interrupted while removing the source, finish that before moving the files back.
+			log.Printf("Removing the rest of %s, which was moved to %s", p.Src, p.Dest)
+			err := os.RemoveAll(p.Src)
+			if err != nil {
+				return err
+			}
+		}
+		srcExists, err := pathExists(p.Src)
+		if err != nil {
+			return err
+		}
+		destExists, err := pathExists(p.Dest)
+		if err != nil {
+			return err
+		}
+		switch {
+		case srcExists && destExists:
+			err = os.RemoveAll(p.Dest)
+		case destExists && entry.Mode == placementMove:
+			log.Printf("Moving %s back to %s", p.Dest, p.Src)
+			err = moveTree(p.Dest, p.Src, nil, func() error {
+				// The source is complete again, the destination is about to be removed.
+				return markCopied(entry, p, false, save)
+			})
+		case destExists:
+			err = os.RemoveAll(p.Dest)
+		case !srcExists:
+			log.Printf("Neither %s nor %s exist", p.Src, p.Dest)
+		}
+		if err != nil {
+			return err
+		}
+		if p.Copied {
+			// Moved back by a rename, which leaves no partial copies behind.
+			err = markCopied(entry, p, false, save)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if entry.Container != "" {
+		err := os.Remove(entry.Container)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	for i := len(entry.Directories) - 1; i >= 0; i-- {
+		// Directories that are not empty were merged into by other placements and stay.
+		err := os.Remove(entry.Directories[i])
+		if err != nil && !os.IsNotExist(err) && !errors.Is(err, syscall.ENOTEMPTY) && !errors.Is(err, syscall.EEXIST) {
+			return err
+		}
+	}
+	return nil
+}
+
+// rollBackJournal undoes placements interrupted by a crash. Must be called before the work directory is cleaned up.
+func rollBackJournal(store *Store) error {
+	entries, err := store.LoadJournal()
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		log.Printf("Rolling back unfinished placement of torrent %s", entry.TorrentId)
+		err = rollBack(entry, store.PutJournal)
+		if err != nil {
+			return fmt.Errorf("could not roll back placement of torrent %s: %w", entry.TorrentId, err)
+		}
+		err = store.DeleteJournal(entry.TorrentId)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func pathExists(path string) (bool, error) {
+	_, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,165 @@
+package torrents
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTree creates the files under root with their contents, along with their parent directories.
+func writeTree(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		filePath := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(filePath), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filePath, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readTree returns the contents of the regular files under root keyed by their path relative to root,
+// and directories with an empty content.
+func readTree(t *testing.T, root string) map[string]string {
+	t.Helper()
+	tree := make(map[string]string)
+	for _, relPath := range listTree(t, root) {
+		filePath := filepath.Join(root, relPath)
+		info, err := os.Stat(filePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.IsDir() {
+			tree[relPath+"/"] = ""
+			continue
+		}
+		content, err := os.ReadFile(filePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tree[relPath] = string(content)
+	}
+	return tree
+}
+
+func checkTree(t *testing.T, root string, want map[string]string) {
+	t.Helper()
+	got := readTree(t, root)
+	for name, content := range want {
+		if gotContent, found := got[name]; !found {
+			t.Errorf("%s is missing", name)
+		} else if gotContent != content {
+			t.Errorf("%s contains %q, want %q", name, gotContent, content)
+		}
+	}
+	for name := range got {
+		if _, found := want[name]; !found {
+			t.Errorf("%s is left behind", name)
+		}
+	}
+}
+
+func TestRollBackJournal(t *testing.T) {
+	root := t.TempDir()
+	work := filepath.Join(root, "work")
+	library := filepath.Join(root, "library")
+	store, err := OpenStore(filepath.Join(root, "lich.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	// What the crashed placements left behind.
+	writeTree(t, work, map[string]string{
+		// The move of the first file was done, the second one was not started.
+		"moved/b.txt": "b",
+		// Copied and verified between file systems, then interrupted while removing the source.
+		"copied/a.txt": "a",
+		// Partially linked and partially copied, the sources are intact.
+		"linked/a.txt": "a", "linked/b.txt": "b",
+		"copying/a.txt": "a", "copying/b.txt": "b",
+	})
+	writeTree(t, library, map[string]string{
+		"Moved/a.txt":              "a",
+		"Copied/a.txt":             "a",
+		"Copied/b.txt":             "b",
+		"Show/Season 01/a.txt":     "a",
+		"Copying/a.txt":            "a",
+		"Copying/b.txt":            "trunc",
+		"Existing/kept.txt":        "kept",
+		"Existing/Season 02/x.txt": "x",
+	})
+	entries := []*journalEntry{
+		{
+			TorrentId: "move",
+			Mode:      placementMove,
+			Container: filepath.Join(library, "Moved"),
+			Placements: []placement{
+				{Src: filepath.Join(work, "moved/a.txt"), Dest: filepath.Join(library, "Moved/a.txt")},
+				{Src: filepath.Join(work, "moved/b.txt"), Dest: filepath.Join(library, "Moved/b.txt")},
+				{Src: filepath.Join(work, "copied"), Dest: filepath.Join(library, "Copied"), Copied: true},
+			},
+		},
+		{
+			TorrentId:   "link",
+			Mode:        placementLink,
+			Directories: []string{filepath.Join(library, "Show"), filepath.Join(library, "Show/Season 01")},
+			Placements: []placement{
+				{Src: filepath.Join(work, "linked/a.txt"), Dest: filepath.Join(library, "Show/Season 01/a.txt")},
+				{Src: filepath.Join(work, "linked/b.txt"), Dest: filepath.Join(library, "Show/Season 01/b.txt")},
+			},
+		},
+		{
+			TorrentId: "copy",
+			Mode:      placementCopy,
+			// Merged into by another placement, so it stays.
+			Directories: []string{filepath.Join(library, "Existing")},
+			Placements: []placement{
+				{Src: filepath.Join(work, "copying"), Dest: filepath.Join(library, "Copying")},
+			},
+		},
+	}
+	for _, entry := range entries {
+		if err := store.PutJournal(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wantWork := map[string]string{
+		"moved/":        "",
+		"moved/a.txt":   "a",
+		"moved/b.txt":   "b",
+		"copied/":       "",
+		"copied/a.txt":  "a",
+		"copied/b.txt":  "b",
+		"linked/":       "",
+		"linked/a.txt":  "a",
+		"linked/b.txt":  "b",
+		"copying/":      "",
+		"copying/a.txt": "a",
+		"copying/b.txt": "b",
+	}
+	wantLibrary := map[string]string{
+		"Existing/":                "",
+		"Existing/kept.txt":        "kept",
+		"Existing/Season 02/":      "",
+		"Existing/Season 02/x.txt": "x",
+	}
+	for run := 1; run <= 2; run++ {
+		err = rollBackJournal(store)
+		if err != nil {
+			t.Fatalf("run %d: rollback failed: %s", run, err)
+		}
+		checkTree(t, work, wantWork)
+		checkTree(t, library, wantLibrary)
+		left, err := store.LoadJournal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(left) != 0 {
+			t.Fatalf("run %d: %d journal entries are left", run, len(left))
+		}
+	}
+}
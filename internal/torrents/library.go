@@ -0,0 +1,241 @@
+package torrents
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/iley/lich/internal/config"
+)
+
+// NewPath must be called under d.libraryMutex. Returns full path.
+func (d *Downloader) NewPath(parentDir string, desiredName string) (string, error) {
+	return newPath(parentDir, desiredName, nil)
+}
+
+// newPath finds a free path like NewPath, also skipping the paths in taken that are planned but do not exist yet.
+func newPath(parentDir string, desiredName string, taken map[string]struct{}) (string, error) {
+	for index := 0; ; index++ {
+		path := path.Join(parentDir, desiredName)
+		if index > 0 {
+			path = fmt.Sprintf("%s_%d", path, index)
+		}
+		if _, found := taken[path]; found {
+			continue
+		}
+		_, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			return path, nil
+		} else if err != nil {
+			return "", err
+		}
+	}
+}
+
+// SafeMkdir must be called under d.libraryMutex.
+func (d *Downloader) SafeMkdir(parentDir string, desiredName string) (string, error) {
+	newPath, err := d.NewPath(parentDir, desiredName)
+	if err == nil {
+		err = os.Mkdir(newPath, 0o755)
+	}
+	if err != nil {
+		return "", err
+	}
+	return newPath, nil
+}
+
+// commonDir returns the placed path if there is only one, or the deepest directory containing all placements.
+func commonDir(placements []placement) string {
+	if len(placements) == 1 {
+		return placements[0].Dest
+	}
+	common := path.Dir(placements[0].Dest)
+	for _, p := range placements[1:] {
+		for common != "/" && common != "." && !strings.HasPrefix(p.Dest, common+"/") {
+			common = path.Dir(common)
+		}
+	}
+	return common
+}
+
+// linkTree recreates the directory structure of src at dest and hardlinks every file.
+func linkTree(src string, dest string) error {
+	return filepath.WalkDir(src, func(srcPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(src, srcPath)
+		if err != nil {
+			return err
+		}
+		destPath := filepath.Join(dest, relPath)
+		if entry.IsDir() {
+			return os.Mkdir(destPath, 0o755)
+		}
+		return os.Link(srcPath, destPath)
+	})
+}
+
+func (d *Downloader) GetTargetDir(category string) string {
+	targetDir, found := d.config.TargetDirs[category]
+	if !found {
+		return d.config.TargetDirs[config.UnsortedCategory]
+	}
+	return targetDir
+}
+
+// LibraryItem records where the files of a completed download were placed, so that they can be moved later.
+type LibraryItem struct {
+	TorrentId string    `json:"torrent_id"`
+	Name      string    `json:"name"`
+	InfoHash  string    `json:"infohash"`
+	Category  string    `json:"category"`
+	PlacedAt  time.Time `json:"placed_at"`
+	// The container, the directory holding all placed files or the only placed path.
+	// For an archive placed on its own, the directory it was extracted into.
+	Path string `json:"path"`
+	// Every placed top-level path. With the series layout these are individual files.
+	Paths []string `json:"paths"`
+	// Directory created to hold the files. Empty if there is none.
+	Container string `json:"container,omitempty"`
+}
+
+// LibraryLayout describes how the files of a torrent are arranged in the library.
+type LibraryLayout struct {
+	// Name of the directory created for torrents with several top-level entries.
+	ContainerName string
+	// Sort episodes into Show/Season NN directories.
+	Series bool
+	// Name of the torrent, used to find the show and season when file names do not contain them.
+	ReleaseName string
+}
+
+// MoveDownloadedFiles moves the files of a torrent into destDir.
+// The progress of copying between file systems is passed to report, which may be nil.
+func (d *Downloader) MoveDownloadedFiles(torrentId string, srcDir string, destDir string, layout LibraryLayout, report ProgressFunc) (*LibraryItem, error) {
+	return d.placeDownloadedFiles(torrentId, srcDir, destDir, layout, placementMove, &moveProgress{report: report})
+}
+
+// LinkDownloadedFiles hardlinks the files of a torrent into destDir, so that the torrent can keep seeding.
+func (d *Downloader) LinkDownloadedFiles(torrentId string, srcDir string, destDir string, layout LibraryLayout) (*LibraryItem, error) {
+	return d.placeDownloadedFiles(torrentId, srcDir, destDir, layout, placementLink, nil)
+}
+
+// CopyDownloadedFiles copies the files of a torrent into destDir, so that the torrent can keep seeding.
+func (d *Downloader) CopyDownloadedFiles(torrentId string, srcDir string, destDir string, layout LibraryLayout, report ProgressFunc) (*LibraryItem, error) {
+	return d.placeDownloadedFiles(torrentId, srcDir, destDir, layout, placementCopy, &moveProgress{report: report})
+}
+
+// placeDownloadedFiles places everything the torrent downloaded into srcDir.
+func (d *Downloader) placeDownloadedFiles(torrentId string, srcDir string, destDir string, layout LibraryLayout, mode string, progress *moveProgress) (*LibraryItem, error) {
+	d.libraryMutex.Lock()
+	defer d.libraryMutex.Unlock()
+
+	fileInfos, err := os.ReadDir(srcDir)
+	if err != nil {
+		return nil, fmt.Errorf("could not list downloaded files: %w", err)
+	}
+	sources := make([]string, 0, len(fileInfos))
+	for _, fileInfo := range fileInfos {
+		entry := fileInfo.Name()
+		if strings.HasSuffix(entry, ".log") {
+			continue
+		}
+		sources = append(sources, path.Join(srcDir, entry))
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no downloaded files found in %s", srcDir)
+	}
+	return d.placeFiles(torrentId, sources, destDir, layout, mode, progress)
+}
+
+// placeFiles plans where each of sources goes, records the plan in the journal and carries it out.
+// The returned item only has the paths filled in. Must be called under d.libraryMutex.
+func (d *Downloader) placeFiles(torrentId string, sources []string, destDir string, layout LibraryLayout, mode string, progress *moveProgress) (*LibraryItem, error) {
+	if progress != nil {
+		for _, source := range sources {
+			size, err := treeSize(source)
+			if err != nil {
+				return nil, err
+			}
+			progress.total += size
+		}
+	}
+
+	journal := &journalEntry{TorrentId: torrentId, Mode: mode}
+	planned := false
+	var err error
+	if layout.Series {
+		planned, err = d.planSeries(journal, sources, destDir, layout.ReleaseName)
+		if err != nil {
+			return nil, fmt.Errorf("could not plan series layout: %w", err)
+		}
+	}
+	if !planned {
+		if len(sources) > 1 {
+			// Created by runJournal once the plan is recorded, so that a crash cannot leave it behind unnoticed.
+			destDir, err = d.NewPath(destDir, layout.ContainerName)
+			if err != nil {
+				return nil, fmt.Errorf("could not pick a directory name in %s: %w", destDir, err)
+			}
+			journal.Container = destDir
+		}
+		taken := make(map[string]struct{})
+		for _, source := range sources {
+			dest, err := newPath(destDir, path.Base(source), taken)
+			if err != nil {
+				return nil, err
+			}
+			taken[dest] = struct{}{}
+			journal.Placements = append(journal.Placements, placement{Src: source, Dest: dest})
+		}
+	}
+
+	err = d.runJournal(journal, progress)
+	if err != nil {
+		return nil, fmt.Errorf("could not place files into %s: %w", destDir, err)
+	}
+	item := &LibraryItem{TorrentId: torrentId, Container: journal.Container}
+	for _, p := range journal.Placements {
+		item.Paths = append(item.Paths, p.Dest)
+	}
+	item.Path = journal.Container
+	if item.Path == "" {
+		item.Path = commonDir(journal.Placements)
+	}
+	return item, nil
+}
+
+// removeLeftovers removes the directories left empty after paths were moved away, up to but excluding root.
+// The series layout moves files out of directories one by one, so paths themselves may be left as empty trees.
+func removeLeftovers(paths []string, root string) {
+	for _, p := range paths {
+		removeEmptyDirs(p)
+		for dir := path.Dir(p); strings.HasPrefix(dir, root+"/"); dir = path.Dir(dir) {
+			if os.Remove(dir) != nil {
+				break
+			}
+		}
+	}
+}
+
+// removeEmptyDirs removes dir and its subdirectories if they contain no files. Reports whether dir is gone.
+func removeEmptyDirs(dir string) bool {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return true
+	} else if err != nil {
+		return false
+	}
+	empty := true
+	for _, entry := range entries {
+		if !entry.IsDir() || !removeEmptyDirs(path.Join(dir, entry.Name())) {
+			empty = false
+		}
+	}
+	return empty && os.Remove(dir) == nil
+}
@@ -0,0 +1,170 @@
+package torrents
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// ProgressFunc receives the number of bytes copied so far and the total number of bytes to move.
+type ProgressFunc func(done int64, total int64)
+
+// moveProgress aggregates the progress of moving several entries of a torrent.
+type moveProgress struct {
+	done   int64
+	total  int64
+	report ProgressFunc
+}
+
+// skip accounts for bytes moved without copying.
+func (p *moveProgress) skip(n int64) {
+	if p != nil {
+		p.done += n
+	}
+}
+
+func (p *moveProgress) Write(b []byte) (int, error) {
+	p.done += int64(len(b))
+	if p.report != nil {
+		p.report(p.done, p.total)
+	}
+	return len(b), nil
+}
+
+// moveTree renames src to dest. If they are on different file systems it copies src, verifies the copy and
+// removes src. A failed copy is removed, so dest either holds a complete copy or does not exist.
+// If copied is not nil, it is called between verifying the copy and removing src, and src stays if it fails.
+func moveTree(src string, dest string, progress *moveProgress, copied func() error) error {
+	err := os.Rename(src, dest)
+	if err == nil {
+		if progress != nil {
+			size, _ := treeSize(dest)
+			progress.skip(size)
+		}
+		return nil
+	}
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	log.Printf("%s and %s are on different file systems, copying", src, dest)
+	err = copyTree(src, dest, progress)
+	if err != nil {
+		os.RemoveAll(dest)
+		return fmt.Errorf("could not copy %s to %s: %w", src, dest, err)
+	}
+	if copied != nil {
+		err = copied()
+		if err != nil {
+			os.RemoveAll(dest)
+			return err
+		}
+	}
+	return os.RemoveAll(src)
+}
+
+func copyTree(src string, dest string, progress *moveProgress) error {
+	return filepath.WalkDir(src, func(srcPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(src, srcPath)
+		if err != nil {
+			return err
+		}
+		destPath := filepath.Join(dest, relPath)
+		if entry.IsDir() {
+			return os.Mkdir(destPath, 0o755)
+		}
+		if !entry.Type().IsRegular() {
+			return fmt.Errorf("%s is not a regular file", srcPath)
+		}
+		return copyFile(srcPath, destPath, progress)
+	})
+}
+
+// copyFile copies a regular file and checks that the copy has the same size and checksum as the original.
+func copyFile(src string, dest string, progress *moveProgress) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	srcHash := sha256.New()
+	writers := []io.Writer{out, srcHash}
+	if progress != nil {
+		writers = append(writers, progress)
+	}
+	written, err := io.Copy(io.MultiWriter(writers...), in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if written != info.Size() {
+		return fmt.Errorf("copied %d bytes of %s, expected %d", written, src, info.Size())
+	}
+	destHash, err := fileHash(dest)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(srcHash.Sum(nil), destHash.Sum(nil)) {
+		return fmt.Errorf("checksum of %s does not match the original", dest)
+	}
+	return nil
+}
+
+func fileHash(path string) (hash.Hash, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, file)
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// treeSize returns the total size of the regular files under path.
+func treeSize(path string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(path, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.Type().IsRegular() {
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
@@ -0,0 +1,76 @@
+package torrents
+
+import (
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	defaultNameTemplate = "{name}"
+	// Leaves room for the collision suffix added by NewPath within the usual 255 byte limit.
+	maxNameLength = 240
+)
+
+// nameFields are the values available to naming templates.
+type nameFields struct {
+	Name     string
+	InfoHash string
+	Category string
+	Date     time.Time
+}
+
+// expandNameTemplate substitutes the fields into a naming template and makes the result safe to use as a file name.
+func expandNameTemplate(template string, fields nameFields) string {
+	if template == "" {
+		template = defaultNameTemplate
+	}
+	replacer := strings.NewReplacer(
+		"{name}", fields.Name,
+		"{infohash}", fields.InfoHash,
+		"{category}", fields.Category,
+		"{date}", fields.Date.Format("2006-01-02"),
+	)
+	return sanitizeName(replacer.Replace(template))
+}
+
+// Names that Windows and therefore SMB shares refuse regardless of the extension.
+var reservedNames = map[string]struct{}{
+	"CON": {}, "PRN": {}, "AUX": {}, "NUL": {},
+	"COM1": {}, "COM2": {}, "COM3": {}, "COM4": {}, "COM5": {}, "COM6": {}, "COM7": {}, "COM8": {}, "COM9": {},
+	"LPT1": {}, "LPT2": {}, "LPT3": {}, "LPT4": {}, "LPT5": {}, "LPT6": {}, "LPT7": {}, "LPT8": {}, "LPT9": {},
+}
+
+// sanitizeName replaces characters that are illegal on common file systems, drops trailing dots and spaces
+// that SMB shares do not allow and limits the length of the name.
+func sanitizeName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || strings.ContainsRune(`<>:"/\|?*`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	name = truncateUTF8(name, maxNameLength)
+	name = strings.TrimLeft(name, " ")
+	name = strings.TrimRight(name, ". ")
+	if name == "" {
+		return "torrent"
+	}
+	base := strings.ToUpper(strings.SplitN(name, ".", 2)[0])
+	if _, found := reservedNames[base]; found {
+		name = "_" + name
+	}
+	return name
+}
+
+// truncateUTF8 cuts s to at most maxBytes bytes without splitting a character.
+func truncateUTF8(s string, maxBytes int) string {
+	if len(s) <= maxBytes {
+		return s
+	}
+	for maxBytes > 0 && !utf8.RuneStart(s[maxBytes]) {
+		maxBytes--
+	}
+	return s[:maxBytes]
+}
@@ -0,0 +1,71 @@
+package torrents
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSanitizeName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Movie Name (2019)", "Movie Name (2019)"},
+		{`What? A "Movie": Part 1/2`, `What_ A _Movie__ Part 1_2`},
+		{"Tab\tand\nnewline", "Tab_and_newline"},
+		{`back\slash|pipe*star<>`, "back_slash_pipe_star__"},
+		{"Trailing dots...", "Trailing dots"},
+		{"Trailing spaces . . ", "Trailing spaces"},
+		{"  Leading spaces", "Leading spaces"},
+		{"", "torrent"},
+		{" . ", "torrent"},
+		{"...", "torrent"},
+		{"CON", "_CON"},
+		{"con", "_con"},
+		{"Aux.txt", "_Aux.txt"},
+		{"LPT9.tar.gz", "_LPT9.tar.gz"},
+		{"COM10", "COM10"},
+		{"CONSOLE", "CONSOLE"},
+		{"NUL ", "_NUL"},
+		{strings.Repeat("a", 300), strings.Repeat("a", maxNameLength)},
+		// Cut before the multi-byte character that would cross the limit.
+		{strings.Repeat("a", maxNameLength-1) + "ä", strings.Repeat("a", maxNameLength-1)},
+		{strings.Repeat("a", maxNameLength-2) + "ä", strings.Repeat("a", maxNameLength-2) + "ä"},
+		// Truncation can expose trailing dots.
+		{strings.Repeat("a", maxNameLength-1) + ".b", strings.Repeat("a", maxNameLength-1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeName(tt.name); got != tt.want {
+				t.Errorf("sanitizeName(%q) = %q, want %q", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpandNameTemplate(t *testing.T) {
+	fields := nameFields{
+		Name:     "Some/Release",
+		InfoHash: "0123abcd",
+		Category: "movies",
+		Date:     time.Date(2024, 3, 9, 12, 0, 0, 0, time.UTC),
+	}
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{"", "Some_Release"},
+		{"{name}", "Some_Release"},
+		{"{date} {name} [{infohash}]", "2024-03-09 Some_Release [0123abcd]"},
+		{"{category}/{name}", "movies_Some_Release"},
+		{"{unknown}", "{unknown}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			if got := expandNameTemplate(tt.template, fields); got != tt.want {
+				t.Errorf("expandNameTemplate(%q) = %q, want %q", tt.template, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package torrents
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/cenkalti/rain/torrent"
+)
+
+// Pause stops a torrent until it is resumed by the user.
+func (d *Downloader) Pause(torrentId string) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	err := d.pause(torrentId)
+	if err != nil {
+		return err
+	}
+	d.startQueued()
+	return nil
+}
+
+// Resume restarts a paused torrent. The torrent is queued if there are no free download slots.
+func (d *Downloader) Resume(torrentId string) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return d.resume(torrentId)
+}
+
+// PauseAll pauses every download. Returns the number of paused torrents.
+func (d *Downloader) PauseAll() (int, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	// Mark the torrents first so that Cleanup never sees them stopped without the flag.
+	paused := make([]*DownloadRequest, 0)
+	for _, req := range d.downloads {
+		if !req.Paused {
+			req.Paused = true
+			paused = append(paused, req)
+		}
+	}
+	err := d.session.StopAll()
+	if err != nil {
+		for _, req := range paused {
+			req.Paused = false
+		}
+		return 0, fmt.Errorf("could not stop torrents: %w", err)
+	}
+	log.Printf("Paused %d torrents", len(paused))
+	// Every queued torrent is paused now, resuming queues them again if needed.
+	d.queue = make([]string, 0)
+	d.persistQueue()
+	for _, req := range paused {
+		d.persistRequest(req)
+	}
+	return len(paused), nil
+}
+
+// ResumeAll resumes every paused download. Torrents that do not get a free download slot are queued.
+// Returns the number of resumed torrents.
+func (d *Downloader) ResumeAll() (int, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	resumed := make([]*DownloadRequest, 0)
+	started := make([]*torrent.Torrent, 0)
+	failed := 0
+	for torrentId, req := range d.downloads {
+		torr := d.session.GetTorrent(torrentId)
+		if !req.Paused || torr == nil {
+			continue
+		}
+		// Checked before clearing the flag, which makes the torrent count as active.
+		queued := !req.Completed && !d.hasFreeSlot()
+		req.Paused = false
+		resumed = append(resumed, req)
+		if queued {
+			d.queue = append(d.queue, torrentId)
+		} else {
+			started = append(started, torr)
+		}
+	}
+
+	if d.allRunnable() {
+		err := d.session.StartAll()
+		if err != nil {
+			for _, req := range resumed {
+				req.Paused = true
+				d.dequeue(req.TorrentId)
+			}
+			return 0, fmt.Errorf("could not start torrents: %w", err)
+		}
+	} else {
+		// Session.StartAll would also start queued, failed and prepared torrents.
+		running := make([]*torrent.Torrent, 0, len(started))
+		for _, torr := range started {
+			err := torr.Start()
+			if err != nil {
+				log.Printf("Could not start torrent %s: %s", torr.Name(), err)
+				d.downloads[torr.ID()].Paused = true
+				failed++
+				continue
+			}
+			running = append(running, torr)
+		}
+		started = running
+	}
+	log.Printf("Resumed %d torrents", len(resumed)-failed)
+	d.persistQueue()
+	for _, req := range resumed {
+		d.persistRequest(req)
+	}
+	for _, torr := range started {
+		d.watch(torr)
+	}
+	return len(resumed) - failed, nil
+}
+
+// allRunnable reports whether every torrent in the session is meant to run. Must be called under d.mutex.
+func (d *Downloader) allRunnable() bool {
+	for _, torr := range d.session.ListTorrents() {
+		req, found := d.downloads[torr.ID()]
+		if !found || req.Paused || req.Error != "" || d.queuePosition(torr.ID()) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// pause must be called under d.mutex.
+func (d *Downloader) pause(torrentId string) error {
+	req, found := d.downloads[torrentId]
+	torr := d.session.GetTorrent(torrentId)
+	if !found || torr == nil {
+		return fmt.Errorf("torrent %s not found", torrentId)
+	}
+	if req.Paused {
+		return fmt.Errorf("torrent %s is already paused", torrentId)
+	}
+
+	log.Printf("Pausing torrent %s", torr.Name())
+	// Mark the torrent first so that Cleanup never sees it stopped without the flag.
+	req.Paused = true
+	d.dequeue(torrentId)
+	err := torr.Stop()
+	if err != nil {
+		req.Paused = false
+		return fmt.Errorf("could not stop torrent %s: %w", torrentId, err)
+	}
+	d.persistRequest(req)
+	return nil
+}
+
+// resume must be called under d.mutex.
+func (d *Downloader) resume(torrentId string) error {
+	req, found := d.downloads[torrentId]
+	torr := d.session.GetTorrent(torrentId)
+	if !found || torr == nil {
+		return fmt.Errorf("torrent %s not found", torrentId)
+	}
+	if !req.Paused {
+		return fmt.Errorf("torrent %s is not paused", torrentId)
+	}
+
+	// Checked before clearing the flag, which makes the torrent count as active.
+	queued := !req.Completed && !d.hasFreeSlot()
+	req.Paused = false
+	if queued {
+		log.Printf("Queueing resumed torrent %s", torr.Name())
+		d.queue = append(d.queue, torrentId)
+		d.persistQueue()
+		d.persistRequest(req)
+		return nil
+	}
+
+	log.Printf("Resuming torrent %s", torr.Name())
+	err := torr.Start()
+	if err != nil {
+		req.Paused = true
+		return fmt.Errorf("could not start torrent %s: %w", torrentId, err)
+	}
+	d.watch(torr)
+	d.persistRequest(req)
+	return nil
+}
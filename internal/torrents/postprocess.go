@@ -0,0 +1,318 @@
+package torrents
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"time"
+
+	"github.com/cenkalti/rain/torrent"
+
+	"github.com/iley/lich/internal/config"
+	"github.com/iley/lich/internal/format"
+)
+
+const (
+	postprocessWorkers   = 2
+	postprocessQueueSize = 64
+)
+
+// watch follows a started torrent and hands it over to post-processing as soon as it completes.
+// Must be called right after the torrent is started.
+func (d *Downloader) watch(torr *torrent.Torrent) {
+	completeC := torr.NotifyComplete()
+	stopC := torr.NotifyStop()
+	closeC := torr.NotifyClose()
+	go func() {
+		select {
+		case <-completeC:
+			d.mutex.Lock()
+			defer d.mutex.Unlock()
+			d.schedulePostprocess(torr.ID())
+		case err := <-stopC:
+			if err == nil {
+				return
+			}
+			d.mutex.Lock()
+			var notices []notice
+			req, found := d.downloads[torr.ID()]
+			if found && req.Error == "" && !req.Completed {
+				notices = d.fail(torr, req, err)
+			}
+			d.mutex.Unlock()
+			d.sendNotices(notices)
+		case <-closeC:
+		}
+	}()
+}
+
+// schedulePostprocess must be called under d.mutex.
+func (d *Downloader) schedulePostprocess(torrentId string) {
+	if _, found := d.processing[torrentId]; found {
+		return
+	}
+	select {
+	case d.postprocessC <- torrentId:
+		d.processing[torrentId] = struct{}{}
+	default:
+		log.Printf("Post-processing queue is full, torrent %s will be picked up by the next cleanup", torrentId)
+	}
+}
+
+// RunPostprocessWorker places completed torrents into the library and removes them once they are done seeding.
+// It does not hold d.mutex while it touches the file system or talks to Telegram.
+func (d *Downloader) RunPostprocessWorker(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case torrentId := <-d.postprocessC:
+			d.postprocess(torrentId)
+		}
+	}
+}
+
+func (d *Downloader) postprocess(torrentId string) {
+	d.mutex.Lock()
+	req, found := d.downloads[torrentId]
+	torr := d.session.GetTorrent(torrentId)
+	if !found || torr == nil {
+		delete(d.processing, torrentId)
+		d.mutex.Unlock()
+		return
+	}
+	stats := torr.Stats()
+	policy := d.config.SeedingPolicy(req.Category)
+	// Stopped torrents do not seed, so there is no point in waiting for them.
+	remove := stats.Status != torrent.Seeding || policy.Satisfied(seedRatio(stats), stats.SeededFor)
+	firstCompletion := !req.Completed
+	chatId := req.ChatId
+	var notices []notice
+	if firstCompletion {
+		log.Printf("Torrent %s completed", torr.Name())
+		req.Completed = true
+		notices = d.finishStatusMessage(req, fmt.Sprintf("Downloaded [%s] %s", req.Category, torr.Name()))
+		d.persistRequest(req)
+	}
+	srcDir := torr.Dir()
+	targetDir := d.GetTargetDir(req.Category)
+	placed := req.LibraryPath != ""
+	placement := d.config.Placement(req.Category)
+	category := req.Category
+	username := req.Username
+	options := d.config.Category(req.Category)
+	layout := LibraryLayout{
+		ContainerName: expandNameTemplate(options.NameTemplate, nameFields{
+			Name:     stats.Name,
+			InfoHash: stats.InfoHash.String(),
+			Category: req.Category,
+			Date:     time.Now(),
+		}),
+		Series:      options.Layout == config.LayoutSeries,
+		ReleaseName: stats.Name,
+	}
+	d.mutex.Unlock()
+
+	if firstCompletion && chatId != 0 {
+		text := fmt.Sprintf("Download of [%s] %s completed", category, torr.Name())
+		d.sendNotices(append(notices, notice{chatId: chatId, text: text}))
+	}
+
+	var item *LibraryItem
+	var err error
+	if !placed {
+		item, err = d.place(torrentId, torr.Name(), chatId, placement, remove, srcDir, targetDir, layout)
+	}
+	if item != nil {
+		item.Name = torr.Name()
+		item.InfoHash = stats.InfoHash.String()
+		item.Category = category
+		item.PlacedAt = time.Now()
+		if options.ExtractArchives {
+			d.mutex.Lock()
+			// Do not place the files again if extraction gets interrupted.
+			d.recordPlacement(req, item)
+			d.mutex.Unlock()
+			d.extract(chatId, item, options.DeleteArchives)
+		}
+		if d.mediaServer != nil {
+			go d.mediaServer.RefreshWithRetries(d.ctx, category, scanPath(item.Path))
+		}
+		if options.SendToChatMaxSize > 0 && chatId != 0 {
+			go d.sendToChat(chatId, item, options.SendToChatMaxSize, options.SendToChatZip)
+		}
+		if len(options.OnComplete) > 0 {
+			go d.runHooks(options.OnComplete, hookEnv{
+				Path:     item.Path,
+				Category: category,
+				Name:     item.Name,
+				InfoHash: item.InfoHash,
+				User:     username,
+				ChatId:   chatId,
+			})
+		}
+	}
+
+	result := d.finishPostprocess(torr, req, item, remove, err)
+	if result != "" && chatId != 0 {
+		d.messenger.SendReply(chatId, result)
+	}
+}
+
+// place puts the files of a completed torrent into the library according to the placement mode of its category.
+// With PlacementMove nothing happens until the torrent is removed. Returns nil if nothing was placed.
+func (d *Downloader) place(torrentId string, name string, chatId int64, placement string, remove bool, srcDir string, targetDir string, layout LibraryLayout) (*LibraryItem, error) {
+	switch placement {
+	case config.PlacementHardlink:
+		item, err := d.LinkDownloadedFiles(torrentId, srcDir, targetDir, layout)
+		if err == nil {
+			return item, nil
+		}
+		log.Printf("Could not link downloaded files, copying them instead: %s", err)
+		fallthrough
+	case config.PlacementCopy:
+		reporter := d.newMoveReporter(chatId, name)
+		item, err := d.CopyDownloadedFiles(torrentId, srcDir, targetDir, layout, reporter.report)
+		reporter.finish(err)
+		return item, err
+	default:
+		if !remove {
+			// The torrent has to keep seeding from the work directory.
+			return nil, nil
+		}
+		reporter := d.newMoveReporter(chatId, name)
+		item, err := d.MoveDownloadedFiles(torrentId, srcDir, targetDir, layout, reporter.report)
+		reporter.finish(err)
+		return item, err
+	}
+}
+
+// finishPostprocess records the outcome of post-processing and removes the torrent if it is done.
+// Returns a notice for the user, if any.
+func (d *Downloader) finishPostprocess(torr *torrent.Torrent, req *DownloadRequest, item *LibraryItem, remove bool, err error) string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	torrentId := torr.ID()
+	delete(d.processing, torrentId)
+	if _, found := d.downloads[torrentId]; !found {
+		return ""
+	}
+	if item != nil {
+		d.recordPlacement(req, item)
+	}
+	if err != nil {
+		if err.Error() == req.MoveError {
+			// The next cleanup retries the move, so only report new errors.
+			return ""
+		}
+		req.MoveError = err.Error()
+		d.persistRequest(req)
+		return fmt.Sprintf("Could not place [%s] %s into the library, will retry: %s", req.Category, torr.Name(), err)
+	}
+	if !remove {
+		return ""
+	}
+	log.Printf("Removing torrent %s from session", torrentId)
+	err = d.session.RemoveTorrent(torrentId)
+	if err != nil {
+		log.Printf("could not remove torrent from session: %s", err)
+		return ""
+	}
+	d.forget(torrentId)
+	d.startQueued()
+	return ""
+}
+
+// scanPath returns the directory a media server should scan to find the placed files.
+func scanPath(libraryPath string) string {
+	info, err := os.Stat(libraryPath)
+	if err == nil && info.IsDir() {
+		return libraryPath
+	}
+	return path.Dir(libraryPath)
+}
+
+// recordPlacement remembers where the files of a torrent were placed. Must be called under d.mutex.
+func (d *Downloader) recordPlacement(req *DownloadRequest, item *LibraryItem) {
+	err := d.store.PutLibraryItem(item)
+	if err != nil {
+		log.Printf("Could not record library item for torrent %s: %s", item.TorrentId, err)
+	}
+	if _, found := d.downloads[req.TorrentId]; !found {
+		return
+	}
+	req.LibraryPath = item.Path
+	req.MoveError = ""
+	d.persistRequest(req)
+}
+
+// extract unpacks the archives among the placed files and reports failures to the chat.
+func (d *Downloader) extract(chatId int64, item *LibraryItem, deleteArchives bool) {
+	err := d.extractArchives(item, deleteArchives)
+	if err != nil {
+		log.Printf("Could not extract archives of torrent %s: %s", item.Name, err)
+		if chatId != 0 {
+			d.messenger.SendReply(chatId, fmt.Sprintf("Could not extract archives of [%s] %s: %s", item.Category, item.Name, err))
+		}
+	}
+}
+
+// Moves smaller than this are not worth reporting to the chat.
+const largeMoveSize = 1 << 30
+
+// moveReporter shows the progress of copying a large torrent in a chat message.
+type moveReporter struct {
+	downloader *Downloader
+	chatId     int64
+	name       string
+	messageId  int
+	lastReport time.Time
+}
+
+func (d *Downloader) newMoveReporter(chatId int64, name string) *moveReporter {
+	return &moveReporter{downloader: d, chatId: chatId, name: name}
+}
+
+func (r *moveReporter) report(done int64, total int64) {
+	if r.chatId == 0 || total < largeMoveSize {
+		return
+	}
+	if time.Since(r.lastReport) < r.downloader.config.ProgressUpdateInterval() {
+		return
+	}
+	r.lastReport = time.Now()
+	text := fmt.Sprintf("Placing %s into the library\n%s %s of %s",
+		r.name, format.ProgressBar(done, total), format.Percent(done, total), format.Bytes(total))
+	r.update(text)
+}
+
+func (r *moveReporter) finish(err error) {
+	if r.messageId == 0 {
+		return
+	}
+	if err != nil {
+		r.update(fmt.Sprintf("Could not place %s into the library", r.name))
+	} else {
+		r.update(fmt.Sprintf("Placed %s into the library", r.name))
+	}
+}
+
+func (r *moveReporter) update(text string) {
+	messenger := r.downloader.messenger
+	if r.messageId == 0 {
+		messageId, err := messenger.SendMessage(r.chatId, text)
+		if err != nil {
+			log.Printf("Could not send move progress to chat %d: %s", r.chatId, err)
+			return
+		}
+		r.messageId = messageId
+		return
+	}
+	err := messenger.EditMessage(r.chatId, r.messageId, text)
+	if err != nil {
+		log.Printf("Could not update move progress in chat %d: %s", r.chatId, err)
+	}
+}
@@ -0,0 +1,150 @@
+package torrents
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cenkalti/rain/torrent"
+)
+
+// Torrents added by Prepare that were never started are removed after this long.
+const pendingTimeout = 6 * time.Hour
+
+// Preview describes the contents of a torrent before the user commits to downloading it.
+type Preview struct {
+	// ID of the torrent prepared in the session. Empty if metadata could not be fetched.
+	TorrentId string
+	Name      string
+	// Zero if metadata is not available.
+	Size  int64
+	Files []PreviewFile
+	// Every file of the torrent with its path inside the torrent.
+	Contents []PreviewFile
+}
+
+// PreviewFile is a file or directory of a torrent.
+type PreviewFile struct {
+	Name string
+	Size int64
+}
+
+// Prepare adds the torrent to the session without downloading its contents and waits up to timeout for metadata.
+// The prepared torrent is started by Add or removed by Discard.
+func (d *Downloader) Prepare(req *DownloadRequest, timeout time.Duration) (*Preview, error) {
+	torr, err := d.addPending(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.TorrentFile == nil {
+		select {
+		case <-torr.NotifyMetadata():
+		case <-time.After(timeout):
+			log.Printf("Timed out waiting for metadata of torrent %s", torr.ID())
+			d.Discard(torr.ID())
+			return &Preview{Name: magnetDisplayName(req.MagnetLink)}, nil
+		}
+	}
+
+	files, err := torr.Files()
+	if err != nil {
+		d.Discard(torr.ID())
+		return nil, fmt.Errorf("could not list torrent files: %w", err)
+	}
+	stats := torr.Stats()
+	return &Preview{
+		TorrentId: torr.ID(),
+		Name:      stats.Name,
+		Size:      stats.Bytes.Total,
+		Files:     topLevelFiles(files),
+		Contents:  allFiles(files),
+	}, nil
+}
+
+func (d *Downloader) addPending(req *DownloadRequest) (*torrent.Torrent, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	var torr *torrent.Torrent
+	var err error
+	if req.TorrentFile != nil {
+		torr, err = d.session.AddTorrent(bytes.NewReader(req.TorrentFile), &torrent.AddTorrentOptions{Stopped: true})
+	} else {
+		torr, err = d.session.AddURI(req.MagnetLink, &torrent.AddTorrentOptions{StopAfterMetadata: true})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not add torrent to session: %w", err)
+	}
+	d.pending[torr.ID()] = time.Now()
+	return torr, nil
+}
+
+// Discard removes a torrent added by Prepare.
+func (d *Downloader) Discard(torrentId string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.discard(torrentId)
+}
+
+// discard must be called under d.mutex.
+func (d *Downloader) discard(torrentId string) {
+	delete(d.pending, torrentId)
+	err := d.session.RemoveTorrent(torrentId)
+	if err != nil {
+		log.Printf("Could not remove prepared torrent %s: %s", torrentId, err)
+	}
+}
+
+// discardStalePending must be called under d.mutex.
+func (d *Downloader) discardStalePending() {
+	for torrentId, addedAt := range d.pending {
+		if time.Since(addedAt) > pendingTimeout {
+			log.Printf("Removing prepared torrent %s that was never started", torrentId)
+			d.discard(torrentId)
+		}
+	}
+}
+
+// topLevelFiles groups files by their first path component below the torrent directory.
+// Paths of multi-file torrents start with the torrent name, single-file torrents consist of one file.
+func topLevelFiles(files []torrent.File) []PreviewFile {
+	result := make([]PreviewFile, 0)
+	index := make(map[string]int)
+	for _, file := range files {
+		parts := strings.Split(filepath.ToSlash(file.Path()), "/")
+		name := parts[0]
+		if len(parts) > 1 {
+			name = parts[1]
+		}
+		i, found := index[name]
+		if !found {
+			i = len(result)
+			index[name] = i
+			result = append(result, PreviewFile{Name: name})
+		}
+		result[i].Size += file.Length()
+	}
+	return result
+}
+
+func allFiles(files []torrent.File) []PreviewFile {
+	result := make([]PreviewFile, 0, len(files))
+	for _, file := range files {
+		result = append(result, PreviewFile{Name: filepath.ToSlash(file.Path()), Size: file.Length()})
+	}
+	return result
+}
+
+func magnetDisplayName(magnetLink string) string {
+	u, err := url.Parse(magnetLink)
+	if err != nil {
+		return ""
+	}
+	return u.Query().Get("dn")
+}
@@ -0,0 +1,148 @@
+package torrents
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/iley/lich/internal/format"
+)
+
+// RunProgressLoop periodically edits the status message of every active download.
+func (d *Downloader) RunProgressLoop(ctx context.Context) {
+	// Telegram rejects edits that do not change the text, so remember what each message says.
+	lastTexts := make(map[int]string)
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("Termination signal received, shutting down the progress loop")
+			return
+		case <-time.After(d.config.ProgressUpdateInterval()):
+			lastTexts = d.UpdateProgressMessages(lastTexts)
+		}
+	}
+}
+
+// UpdateProgressMessages edits status messages whose text differs from lastTexts.
+// Returns the current text of every status message keyed by message ID.
+func (d *Downloader) UpdateProgressMessages(lastTexts map[int]string) map[int]string {
+	d.statusMutex.Lock()
+	defer d.statusMutex.Unlock()
+
+	d.mutex.Lock()
+	texts, edits := d.progressEdits(lastTexts)
+	d.mutex.Unlock()
+
+	for _, edit := range edits {
+		err := d.messenger.EditMessage(edit.chatId, edit.messageId, edit.text)
+		if err != nil {
+			log.Printf("Could not update status message %d in chat %d: %s", edit.messageId, edit.chatId, err)
+		}
+	}
+	return texts
+}
+
+// progressEdits returns the current text of every status message and the edits of the messages whose text differs
+// from lastTexts. Must be called under d.mutex.
+func (d *Downloader) progressEdits(lastTexts map[int]string) (map[int]string, []notice) {
+	texts := make(map[int]string, len(d.downloads))
+	edits := make([]notice, 0)
+	for torrentId, req := range d.downloads {
+		if req.StatusMessageId == 0 {
+			continue
+		}
+		torr := d.session.GetTorrent(torrentId)
+		if torr == nil {
+			continue
+		}
+		entry := d.listEntry(torr)
+		if entry.Status == StatusSeeding {
+			// Completion is reported by Cleanup.
+			continue
+		}
+		text := progressText(entry)
+		texts[req.StatusMessageId] = text
+		if lastTexts[req.StatusMessageId] == text {
+			continue
+		}
+		edits = append(edits, notice{chatId: req.ChatId, messageId: req.StatusMessageId, text: text})
+	}
+	return texts, edits
+}
+
+func progressText(entry DownloadListEntry) string {
+	text := fmt.Sprintf("[%s] %s\n%s %s of %s, %s",
+		entry.Category,
+		entry.Name,
+		format.ProgressBar(entry.BytesCompleted, entry.BytesTotal),
+		format.Percent(entry.BytesCompleted, entry.BytesTotal),
+		format.Bytes(entry.BytesTotal),
+		entry.Status)
+	switch entry.Status {
+	case StatusDownloading:
+		text += fmt.Sprintf("\n↓ %s, ETA %s", format.Speed(entry.DownloadSpeed), format.ETA(entry.ETA))
+	case StatusError:
+		text += fmt.Sprintf("\nError: %s", entry.Error)
+	case StatusQueued:
+		text += fmt.Sprintf("\nPosition in queue: %d", entry.QueuePosition)
+	}
+	return text
+}
+
+// notice is a chat message collected under d.mutex and sent by sendNotices once it is released,
+// so that a slow Bot API does not hold up everyone waiting for the mutex.
+type notice struct {
+	chatId int64
+	// Edit this message instead of sending a new one, if not zero.
+	messageId int
+	text      string
+}
+
+// sendNotices must be called without holding d.mutex.
+func (d *Downloader) sendNotices(notices []notice) {
+	if len(notices) == 0 {
+		return
+	}
+	d.statusMutex.Lock()
+	defer d.statusMutex.Unlock()
+	for _, n := range notices {
+		if n.messageId == 0 {
+			d.messenger.SendReply(n.chatId, n.text)
+			continue
+		}
+		err := d.messenger.EditMessage(n.chatId, n.messageId, n.text)
+		if err != nil {
+			log.Printf("Could not update status message %d in chat %d: %s", n.messageId, n.chatId, err)
+		}
+	}
+}
+
+// finishStatusMessage detaches the progress report from req and returns the edit that replaces it with a final
+// message, if there is a report. Must be called under d.mutex.
+func (d *Downloader) finishStatusMessage(req *DownloadRequest, text string) []notice {
+	if req.StatusMessageId == 0 {
+		return nil
+	}
+	edit := notice{chatId: req.ChatId, messageId: req.StatusMessageId, text: text}
+	req.StatusMessageId = 0
+	return []notice{edit}
+}
+
+// attachStatusMessage sends the message that reports the progress of a download and remembers it in the request,
+// unless the download is gone or has failed in the meantime. Must be called without holding d.mutex.
+func (d *Downloader) attachStatusMessage(torrentId string, chatId int64, text string) {
+	messageId, err := d.messenger.SendMessage(chatId, text)
+	if err != nil {
+		log.Printf("Could not send status message to chat %d: %s", chatId, err)
+		return
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	req, found := d.downloads[torrentId]
+	if !found || req.Error != "" || req.Completed || req.StatusMessageId != 0 {
+		return
+	}
+	req.StatusMessageId = messageId
+	d.persistRequest(req)
+}
@@ -0,0 +1,102 @@
+package torrents
+
+import (
+	"fmt"
+	"log"
+)
+
+// hasFreeSlot reports whether another torrent can start downloading. Must be called under d.mutex.
+func (d *Downloader) hasFreeSlot() bool {
+	limit := d.config.MaxActiveDownloads
+	return limit <= 0 || d.activeDownloads() < limit
+}
+
+// activeDownloads counts torrents that are downloading. Must be called under d.mutex.
+func (d *Downloader) activeDownloads() int {
+	active := 0
+	for torrentId, req := range d.downloads {
+		if !req.Completed && !req.Paused && req.Error == "" && d.queuePosition(torrentId) == 0 {
+			active++
+		}
+	}
+	return active
+}
+
+// startQueued starts queued torrents while there are free download slots. Must be called under d.mutex.
+func (d *Downloader) startQueued() {
+	for len(d.queue) > 0 && d.hasFreeSlot() {
+		torrentId := d.queue[0]
+		d.queue = d.queue[1:]
+		d.persistQueue()
+
+		torr := d.session.GetTorrent(torrentId)
+		if torr == nil {
+			log.Printf("Queued torrent %s is gone", torrentId)
+			continue
+		}
+		log.Printf("Starting queued torrent %s", torr.Name())
+		err := torr.Start()
+		if err != nil {
+			log.Printf("Could not start queued torrent %s: %s", torrentId, err)
+			continue
+		}
+		d.watch(torr)
+	}
+}
+
+// queuePosition returns the position of a torrent in the queue starting from 1, or zero if it is not queued.
+// Must be called under d.mutex.
+func (d *Downloader) queuePosition(torrentId string) int {
+	for i, queuedId := range d.queue {
+		if queuedId == torrentId {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// dequeue must be called under d.mutex.
+func (d *Downloader) dequeue(torrentId string) {
+	position := d.queuePosition(torrentId)
+	if position == 0 {
+		return
+	}
+	d.queue = append(d.queue[:position-1], d.queue[position:]...)
+	d.persistQueue()
+}
+
+// persistQueue must be called under d.mutex.
+func (d *Downloader) persistQueue() {
+	err := d.store.PutQueue(d.queue)
+	if err != nil {
+		log.Printf("Could not persist download queue: %s", err)
+	}
+}
+
+// MoveToTop makes a queued torrent the next one to start.
+func (d *Downloader) MoveToTop(torrentId string) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.queuePosition(torrentId) == 0 {
+		return fmt.Errorf("torrent %s is not queued", torrentId)
+	}
+	d.dequeue(torrentId)
+	d.queue = append([]string{torrentId}, d.queue...)
+	d.persistQueue()
+	return nil
+}
+
+// MoveToBottom makes a queued torrent the last one to start.
+func (d *Downloader) MoveToBottom(torrentId string) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.queuePosition(torrentId) == 0 {
+		return fmt.Errorf("torrent %s is not queued", torrentId)
+	}
+	d.dequeue(torrentId)
+	d.queue = append(d.queue, torrentId)
+	d.persistQueue()
+	return nil
+}
@@ -0,0 +1,183 @@
+package torrents
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/iley/lich/internal/format"
+)
+
+// At most this many files of a download are sent to the chat, the rest can be found in the library.
+const maxSentFiles = 20
+
+// sendToChat uploads the placed files of item of up to maxSize bytes to chatId as documents.
+// With zipDirs, each placed directory is sent as a single zip archive instead.
+func (d *Downloader) sendToChat(chatId int64, item *LibraryItem, maxSize int64, zipDirs bool) {
+	if limit := d.messenger.MaxUploadSize(); limit < maxSize {
+		maxSize = limit
+	}
+	files := make([]string, 0)
+	// Files or, with zipDirs, directories too large to send.
+	skipped := 0
+	for _, placedPath := range item.Paths {
+		info, err := os.Stat(placedPath)
+		if err != nil {
+			log.Printf("Could not send %s: %s", placedPath, err)
+			continue
+		}
+		switch {
+		case !info.IsDir():
+			if info.Size() > maxSize {
+				skipped++
+				continue
+			}
+			files = append(files, placedPath)
+		case zipDirs:
+			archive, err := d.zipDir(placedPath, maxSize)
+			if err != nil {
+				log.Printf("Could not archive %s: %s", placedPath, err)
+				skipped++
+				continue
+			}
+			defer os.RemoveAll(path.Dir(archive))
+			files = append(files, archive)
+		default:
+			err = filepath.WalkDir(placedPath, func(filePath string, entry fs.DirEntry, err error) error {
+				if err != nil || !entry.Type().IsRegular() {
+					return err
+				}
+				info, err := entry.Info()
+				if err != nil {
+					return err
+				}
+				if info.Size() > maxSize {
+					skipped++
+					return nil
+				}
+				files = append(files, filePath)
+				return nil
+			})
+			if err != nil {
+				log.Printf("Could not list files in %s: %s", placedPath, err)
+			}
+		}
+	}
+
+	notices := make([]string, 0)
+	if skipped > 0 {
+		notices = append(notices, fmt.Sprintf("%d items of [%s] %s are too large to send, find them in the library",
+			skipped, item.Category, item.Name))
+	}
+	if len(files) > maxSentFiles {
+		notices = append(notices, fmt.Sprintf("Only the first %d files of [%s] %s are sent, find the other %d in the library",
+			maxSentFiles, item.Category, item.Name, len(files)-maxSentFiles))
+		files = files[:maxSentFiles]
+	}
+	for _, filePath := range files {
+		log.Printf("Sending %s to chat %d", filePath, chatId)
+		err := d.messenger.SendDocument(chatId, filePath, "")
+		if err != nil {
+			log.Printf("Could not send %s to chat %d: %s", filePath, chatId, err)
+			d.messenger.SendReply(chatId, fmt.Sprintf("Could not send %s: %s", path.Base(filePath), err))
+		}
+	}
+	for _, notice := range notices {
+		d.messenger.SendReply(chatId, notice)
+	}
+}
+
+// zipDir packs dir into a zip archive named after it in a new temporary directory under the work directory.
+// Directories with more than maxSize bytes of files are refused without packing them, and so are archives
+// that end up larger than that. Archives left behind by a crash are removed with other orphaned data on start.
+func (d *Downloader) zipDir(dir string, maxSize int64) (string, error) {
+	var total int64
+	err := filepath.WalkDir(dir, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if total > maxSize {
+		return "", fmt.Errorf("%s of files is more than %s", format.Bytes(total), format.Bytes(maxSize))
+	}
+
+	tempDir, err := os.MkdirTemp(d.config.WorkDir, "send-")
+	if err != nil {
+		return "", err
+	}
+	archivePath := path.Join(tempDir, path.Base(dir)+".zip")
+	err = writeZip(archivePath, dir)
+	if err == nil {
+		var info os.FileInfo
+		info, err = os.Stat(archivePath)
+		if err == nil && info.Size() > maxSize {
+			err = fmt.Errorf("archive is larger than %s", format.Bytes(maxSize))
+		}
+	}
+	if err != nil {
+		os.RemoveAll(tempDir)
+		return "", err
+	}
+	return archivePath, nil
+}
+
+// writeZip packs the regular files under dir into a zip archive at archivePath, keeping dir as the top directory.
+func writeZip(archivePath string, dir string) error {
+	file, err := os.OpenFile(archivePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	writer := zip.NewWriter(file)
+	err = filepath.WalkDir(dir, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+		relPath, err := filepath.Rel(path.Dir(dir), filePath)
+		if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(relPath)
+		header.Method = zip.Deflate
+		w, err := writer.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		src, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		_, err = io.Copy(w, src)
+		return err
+	})
+	if err == nil {
+		err = writer.Close()
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,240 @@
+package torrents
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var errNoShow = errors.New("show name not found")
+
+// Start of a marker. Unlike \b, it also works after _ used as a separator.
+const markerStart = `(?:^|[^\p{L}\p{N}])`
+
+var (
+	// Show.Name.S01E02, Show Name - s1e2, Show.Name.S01.E02
+	episodePattern = regexp.MustCompile(`(?i)^(.*?)` + markerStart + `S(\d{1,2})[ ._-]?E\d{1,3}`)
+	// Show.Name.1x02
+	crossEpisodePattern = regexp.MustCompile(`(?i)^(.*?)` + markerStart + `(\d{1,2})x\d{2,3}(?:[^\p{L}\p{N}]|$)`)
+	// Show.Name.S01.1080p, Show Name S01 Complete, Show.Name.S01-GROUP. A bare S01 has to end the name or be
+	// followed by a release tag, so that names like Galaxy S10 Review are not taken for season packs.
+	seasonPattern = regexp.MustCompile(`(?i)^(.*?)` + markerStart + `S(\d{1,2})(?:$|[ ._-]*[\[(]|-\w+$|[ ._-]+` +
+		`(?:complete|\d{3,4}[pi]|web(?:rip|-?dl)?|blu-?ray|[bh]d(?:rip|tv)|dvd(?:rip)?|x26[45]|h\.?26[45]|hevc|multi|proper|repack|remux)` +
+		`(?:[ ._\-\[\]()]|$))`)
+	// Show Name Season 1, Show.Name.Season.01.1080p
+	seasonWordPattern = regexp.MustCompile(`(?i)^(.*?)` + markerStart + `Season[ ._-]?(\d{1,2})(?:[ ._\-\[\]()]|$)`)
+	// Show.Name.2019 or Show Name (2019) at the end of the show part of a release name.
+	showYearPattern = regexp.MustCompile(`^(.*?)[ ._\-(\[]+((?:19|20)\d{2})[)\]]?$`)
+	// Existing season directories: Season 1, Season 01, season 1.
+	seasonDirPattern = regexp.MustCompile(`(?i)^season[ ._-]?0*(\d+)$`)
+)
+
+// releaseInfo is what can be learned about an episode from a file or release name.
+type releaseInfo struct {
+	// Empty if unknown.
+	Show string
+	// -1 if unknown.
+	Season int
+}
+
+// parseRelease extracts the show name and season from a release or file name.
+// The show name is only recognized if it is followed by a season or episode marker.
+func parseRelease(name string) releaseInfo {
+	info := releaseInfo{Season: -1}
+	for _, pattern := range []*regexp.Regexp{episodePattern, crossEpisodePattern, seasonPattern, seasonWordPattern} {
+		match := pattern.FindStringSubmatch(name)
+		if match == nil {
+			continue
+		}
+		season, err := strconv.Atoi(match[2])
+		if err != nil {
+			continue
+		}
+		info.Season = season
+		info.Show = cleanShowName(match[1])
+		return info
+	}
+	return info
+}
+
+// cleanShowName turns the show part of a release name into a directory name: Show.Name.2019 becomes Show Name (2019).
+func cleanShowName(name string) string {
+	name = strings.NewReplacer(".", " ", "_", " ").Replace(name)
+	name = strings.Join(strings.Fields(name), " ")
+	name = strings.Trim(name, " -[(")
+	if match := showYearPattern.FindStringSubmatch(name); match != nil && match[1] != "" {
+		name = fmt.Sprintf("%s (%s)", strings.Trim(match[1], " -[("), match[2])
+	}
+	if name == "" {
+		return ""
+	}
+	return sanitizeName(name)
+}
+
+// showKey normalizes a show name so that differently spelled directories of the same show match.
+func showKey(name string) string {
+	var key strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if ('a' <= r && r <= 'z') || ('0' <= r && r <= '9') || r > 127 {
+			key.WriteRune(r)
+		}
+	}
+	return key.String()
+}
+
+// seriesPlanner keeps track of the directories planned for one torrent.
+type seriesPlanner struct {
+	journal  *journalEntry
+	destDir  string
+	showDirs map[string]string
+	created  map[string]struct{}
+	taken    map[string]struct{}
+}
+
+// planSeries plans the placement of every file under sources into Show/Season NN directories under destDir,
+// merging into existing show and season directories. Returns false if no show name could be found,
+// in which case the files should be placed as they are. Must be called under d.libraryMutex.
+func (d *Downloader) planSeries(journal *journalEntry, sources []string, destDir string, releaseName string) (bool, error) {
+	release := parseRelease(releaseName)
+	planner := &seriesPlanner{
+		journal:  journal,
+		destDir:  destDir,
+		showDirs: make(map[string]string),
+		created:  make(map[string]struct{}),
+		taken:    make(map[string]struct{}),
+	}
+	placements := make([]placement, 0)
+	for _, source := range sources {
+		err := filepath.WalkDir(source, func(srcPath string, dirEntry fs.DirEntry, err error) error {
+			if err != nil || dirEntry.IsDir() {
+				return err
+			}
+			relPath, err := filepath.Rel(path.Dir(source), srcPath)
+			if err != nil {
+				return err
+			}
+			info := episodeInfo(relPath, release)
+			if info.Show == "" {
+				return errNoShow
+			}
+			dir, err := planner.episodeDir(info)
+			if err != nil {
+				return err
+			}
+			dest, err := newPath(dir, path.Base(srcPath), planner.taken)
+			if err != nil {
+				return err
+			}
+			planner.taken[dest] = struct{}{}
+			placements = append(placements, placement{Src: srcPath, Dest: dest})
+			return nil
+		})
+		if errors.Is(err, errNoShow) {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+	}
+	journal.Placements = placements
+	return true, nil
+}
+
+// episodeInfo combines what the file name, its parent directories and the release name say about a file.
+// The closest name wins: an episode file inside a season pack keeps its own season.
+func episodeInfo(relPath string, release releaseInfo) releaseInfo {
+	info := releaseInfo{Season: -1}
+	for name := relPath; name != "." && name != "/"; name = path.Dir(name) {
+		candidate := parseRelease(path.Base(name))
+		if info.Show == "" {
+			info.Show = candidate.Show
+		}
+		if info.Season < 0 {
+			info.Season = candidate.Season
+		}
+	}
+	if info.Show == "" {
+		info.Show = release.Show
+	}
+	if info.Season < 0 {
+		info.Season = release.Season
+	}
+	return info
+}
+
+// episodeDir returns the directory for an episode, planning any directories that do not exist yet.
+// Files without a known season go directly into the show directory.
+func (p *seriesPlanner) episodeDir(info releaseInfo) (string, error) {
+	showDir, err := p.showDir(info.Show)
+	if err != nil {
+		return "", err
+	}
+	if info.Season < 0 {
+		return showDir, nil
+	}
+	seasonDir := path.Join(showDir, fmt.Sprintf("Season %02d", info.Season))
+	if _, planned := p.created[showDir]; !planned {
+		existing, err := findDir(showDir, func(name string) bool {
+			match := seasonDirPattern.FindStringSubmatch(name)
+			return match != nil && match[1] == strconv.Itoa(info.Season)
+		})
+		if err != nil {
+			return "", err
+		}
+		if existing != "" {
+			return existing, nil
+		}
+	}
+	p.plan(seasonDir)
+	return seasonDir, nil
+}
+
+// showDir returns the directory of a show, reusing an existing directory with a matching name.
+func (p *seriesPlanner) showDir(show string) (string, error) {
+	key := showKey(show)
+	if dir, found := p.showDirs[key]; found {
+		return dir, nil
+	}
+	dir, err := findDir(p.destDir, func(name string) bool {
+		return showKey(name) == key
+	})
+	if err != nil {
+		return "", err
+	}
+	if dir == "" {
+		dir = path.Join(p.destDir, show)
+		p.plan(dir)
+	}
+	p.showDirs[key] = dir
+	return dir, nil
+}
+
+// plan records a directory that has to be created before the files are placed.
+func (p *seriesPlanner) plan(dir string) {
+	if _, planned := p.created[dir]; planned {
+		return
+	}
+	p.created[dir] = struct{}{}
+	p.journal.Directories = append(p.journal.Directories, dir)
+}
+
+// findDir returns the first subdirectory of parent whose name satisfies match, or an empty string.
+func findDir(parent string, match func(name string) bool) (string, error) {
+	entries, err := os.ReadDir(parent)
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() && match(entry.Name()) {
+			return path.Join(parent, entry.Name()), nil
+		}
+	}
+	return "", nil
+}
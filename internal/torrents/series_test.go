@@ -0,0 +1,130 @@
+package torrents
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseRelease(t *testing.T) {
+	tests := []struct {
+		name       string
+		wantShow   string
+		wantSeason int
+	}{
+		{"Show.Name.S01E02.1080p.WEB-DL.mkv", "Show Name", 1},
+		{"Show Name - s1e2 - Pilot.mkv", "Show Name", 1},
+		{"Show.Name.S02.E03.mkv", "Show Name", 2},
+		{"Show.Name.1x02.HDTV.avi", "Show Name", 1},
+		{"Show_Name_12x103.mkv", "Show Name", 12},
+		{"Show_Name_S03E04_720p.mkv", "Show Name", 3},
+		{"Show.Name.S01.1080p.BluRay.x264-GROUP", "Show Name", 1},
+		{"Show Name S03 Complete", "Show Name", 3},
+		{"Show.Name.S04-GROUP", "Show Name", 4},
+		{"Show Name S05 [1080p]", "Show Name", 5},
+		{"Show Name S06", "Show Name", 6},
+		{"Show Name Season 1", "Show Name", 1},
+		{"Show.Name.Season.07.720p", "Show Name", 7},
+		{"Show.Name.2019.S01E01.mkv", "Show Name (2019)", 1},
+		{"Show Name (2019) S02 1080p", "Show Name (2019)", 2},
+		{"Show Name [2019] Season 3", "Show Name (2019)", 3},
+		{"S01E05.mkv", "", 1},
+		// Not season markers.
+		{"Galaxy S10 Review", "", -1},
+		{"Galaxy.S10.Unboxing.mp4", "", -1},
+		{"Show Name Seasons Greetings", "", -1},
+		{"Movie.Name.2019.1080p.1920x1080.mkv", "", -1},
+		{"Movie Name", "", -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := parseRelease(tt.name)
+			if info.Show != tt.wantShow || info.Season != tt.wantSeason {
+				t.Errorf("parseRelease(%q) = %q season %d, want %q season %d",
+					tt.name, info.Show, info.Season, tt.wantShow, tt.wantSeason)
+			}
+		})
+	}
+}
+
+func TestPlanSeries(t *testing.T) {
+	root := t.TempDir()
+	work := filepath.Join(root, "work")
+	library := filepath.Join(root, "library")
+	writeTree(t, library, map[string]string{
+		"the show (2019)/season 1/Show.S01E01.mkv": "1",
+		"Other Show/Season 01/Other.S01E01.mkv":    "1",
+	})
+	writeTree(t, work, map[string]string{
+		"The.Show.2019.S01.1080p/The.Show.2019.S01E02.mkv":      "2",
+		"The.Show.2019.S01.1080p/Extras/S02E01.Preview.mkv":     "p",
+		"The.Show.2019.S01.1080p/Sample/sample.mkv":             "s",
+		"The.Show.2019.S01.1080p/Subs/The.Show.2019.S01E02.srt": "t",
+		"The.Show.2019.S01.1080p/New.Show.S01E01.mkv":           "n",
+	})
+	release := "The.Show.2019.S01.1080p"
+
+	d := &Downloader{}
+	journal := &journalEntry{}
+	planned, err := d.planSeries(journal, []string{filepath.Join(work, release)}, library, release)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !planned {
+		t.Fatal("series layout was not planned")
+	}
+
+	wantDests := map[string]string{
+		// Merged into the existing, differently spelled show and season directories.
+		release + "/The.Show.2019.S01E02.mkv":      "the show (2019)/season 1/The.Show.2019.S01E02.mkv",
+		release + "/Sample/sample.mkv":             "the show (2019)/season 1/sample.mkv",
+		release + "/Subs/The.Show.2019.S01E02.srt": "the show (2019)/season 1/The.Show.2019.S01E02.srt",
+		// The file name says season 2, which does not exist yet.
+		release + "/Extras/S02E01.Preview.mkv": "the show (2019)/Season 02/S02E01.Preview.mkv",
+		release + "/New.Show.S01E01.mkv":       "New Show/Season 01/New.Show.S01E01.mkv",
+	}
+	if len(journal.Placements) != len(wantDests) {
+		t.Fatalf("planned %d placements, want %d: %+v", len(journal.Placements), len(wantDests), journal.Placements)
+	}
+	for _, p := range journal.Placements {
+		relSrc, _ := filepath.Rel(work, p.Src)
+		want, found := wantDests[relSrc]
+		if !found {
+			t.Errorf("unexpected placement of %s", relSrc)
+			continue
+		}
+		if want := filepath.Join(library, want); p.Dest != want {
+			t.Errorf("%s is placed at %s, want %s", relSrc, p.Dest, want)
+		}
+	}
+
+	wantDirs := []string{"the show (2019)/Season 02", "New Show", "New Show/Season 01"}
+	if len(journal.Directories) != len(wantDirs) {
+		t.Fatalf("planned directories %v, want %v", journal.Directories, wantDirs)
+	}
+	for i, dir := range wantDirs {
+		if want := filepath.Join(library, dir); journal.Directories[i] != want {
+			t.Errorf("planned directories %v, want %v", journal.Directories, wantDirs)
+		}
+	}
+	// Planning does not touch the file system.
+	if _, err := os.Stat(filepath.Join(library, "New Show")); !os.IsNotExist(err) {
+		t.Errorf("planning created New Show: %v", err)
+	}
+}
+
+func TestPlanSeriesWithoutShow(t *testing.T) {
+	root := t.TempDir()
+	work := filepath.Join(root, "work")
+	writeTree(t, work, map[string]string{"Galaxy S10 Review/review.mp4": "r"})
+
+	d := &Downloader{}
+	journal := &journalEntry{}
+	planned, err := d.planSeries(journal, []string{filepath.Join(work, "Galaxy S10 Review")}, filepath.Join(root, "library"), "Galaxy S10 Review")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if planned || len(journal.Placements) != 0 || len(journal.Directories) != 0 {
+		t.Errorf("planned %+v for a release without a show, want nothing", journal)
+	}
+}
@@ -0,0 +1,192 @@
+package torrents
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+var (
+	downloadsBucket = []byte("downloads")
+	stateBucket     = []byte("state")
+	journalBucket   = []byte("journal")
+	libraryBucket   = []byte("library")
+	queueKey        = []byte("queue")
+)
+
+// Store persists download requests so that they survive restarts.
+type Store struct {
+	db *bbolt.DB
+}
+
+func OpenStore(dbPath string) (*Store, error) {
+	db, err := bbolt.Open(dbPath, 0o600, &bbolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("could not open database %s: %w", dbPath, err)
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, bucket := range [][]byte{downloadsBucket, stateBucket, journalBucket, libraryBucket} {
+			_, err := tx.CreateBucketIfNotExists(bucket)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not initialize database %s: %w", dbPath, err)
+	}
+	return &Store{db: db}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+func (s *Store) PutRequest(req *DownloadRequest) error {
+	value, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(downloadsBucket).Put([]byte(req.TorrentId), value)
+	})
+}
+
+func (s *Store) DeleteRequest(torrentId string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(downloadsBucket).Delete([]byte(torrentId))
+	})
+}
+
+// LoadRequests returns all stored requests keyed by torrent ID.
+func (s *Store) LoadRequests() (map[string]*DownloadRequest, error) {
+	requests := make(map[string]*DownloadRequest)
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(downloadsBucket).ForEach(func(key, value []byte) error {
+			var req DownloadRequest
+			err := json.Unmarshal(value, &req)
+			if err != nil {
+				return fmt.Errorf("could not decode download request %s: %w", string(key), err)
+			}
+			requests[string(key)] = &req
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return requests, nil
+}
+
+// PutQueue stores the IDs of queued torrents in the order they will be started.
+func (s *Store) PutQueue(queue []string) error {
+	value, err := json.Marshal(queue)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(stateBucket).Put(queueKey, value)
+	})
+}
+
+func (s *Store) LoadQueue() ([]string, error) {
+	var queue []string
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		value := tx.Bucket(stateBucket).Get(queueKey)
+		if value == nil {
+			return nil
+		}
+		return json.Unmarshal(value, &queue)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return queue, nil
+}
+
+// PutJournal records a planned placement before any files are touched.
+func (s *Store) PutJournal(entry *journalEntry) error {
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(journalBucket).Put([]byte(entry.TorrentId), value)
+	})
+}
+
+func (s *Store) DeleteJournal(torrentId string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(journalBucket).Delete([]byte(torrentId))
+	})
+}
+
+// LoadJournal returns placements that were started but never finished.
+func (s *Store) LoadJournal() ([]*journalEntry, error) {
+	entries := make([]*journalEntry, 0)
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(journalBucket).ForEach(func(key, value []byte) error {
+			var entry journalEntry
+			err := json.Unmarshal(value, &entry)
+			if err != nil {
+				return fmt.Errorf("could not decode journal entry %s: %w", string(key), err)
+			}
+			entries = append(entries, &entry)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (s *Store) PutLibraryItem(item *LibraryItem) error {
+	value, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(libraryBucket).Put([]byte(item.TorrentId), value)
+	})
+}
+
+// LoadLibraryItem returns nil if the torrent was never placed into the library.
+func (s *Store) LoadLibraryItem(torrentId string) (*LibraryItem, error) {
+	var item *LibraryItem
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		value := tx.Bucket(libraryBucket).Get([]byte(torrentId))
+		if value == nil {
+			return nil
+		}
+		item = &LibraryItem{}
+		return json.Unmarshal(value, item)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+func (s *Store) LoadLibraryItems() ([]*LibraryItem, error) {
+	items := make([]*LibraryItem, 0)
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(libraryBucket).ForEach(func(key, value []byte) error {
+			var item LibraryItem
+			err := json.Unmarshal(value, &item)
+			if err != nil {
+				return fmt.Errorf("could not decode library item %s: %w", string(key), err)
+			}
+			items = append(items, &item)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
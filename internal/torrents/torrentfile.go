@@ -0,0 +1,34 @@
+package torrents
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/zeebo/bencode"
+)
+
+// MaxTorrentFileSize is the largest .torrent file accepted from users.
+const MaxTorrentFileSize = 10 * 1024 * 1024
+
+type torrentFileContents struct {
+	Info bencode.RawMessage `bencode:"info"`
+}
+
+// ValidateTorrentFile checks that data looks like a .torrent file before handing it to the session.
+func ValidateTorrentFile(data []byte) error {
+	if len(data) == 0 {
+		return errors.New("file is empty")
+	}
+	if len(data) > MaxTorrentFileSize {
+		return fmt.Errorf("file is larger than %d bytes", MaxTorrentFileSize)
+	}
+	var contents torrentFileContents
+	err := bencode.DecodeBytes(data, &contents)
+	if err != nil {
+		return fmt.Errorf("file is not bencoded: %w", err)
+	}
+	if len(contents.Info) == 0 {
+		return errors.New("file has no info dictionary")
+	}
+	return nil
+}
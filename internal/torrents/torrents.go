@@ -1,7 +1,9 @@
 package torrents
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -12,85 +14,274 @@ import (
 
 	"github.com/cenkalti/rain/torrent"
 	"github.com/iley/lich/internal/config"
+	"github.com/iley/lich/internal/mediaserver"
 	"golang.org/x/exp/slices"
 )
 
-// ReplyFunc is a function that sends a reply to the user.
-type ReplyFunc func(int64, string)
+// The cleanup loop only backs up the torrent watchers, so it does not need to run often.
+const cleanupInterval = time.Minute
+
+// Messenger sends messages to Telegram chats on behalf of the downloader.
+type Messenger interface {
+	SendReply(chatId int64, text string)
+	// SendMessage returns the ID of the sent message so that it can be edited later.
+	SendMessage(chatId int64, text string) (int, error)
+	EditMessage(chatId int64, messageId int, text string) error
+	SendDocument(chatId int64, filePath string, caption string) error
+	// MaxUploadSize returns the size of the largest file SendDocument can send.
+	MaxUploadSize() int64
+}
 
+// DownloadRequest carries either a magnet link or the contents of a .torrent file.
 type DownloadRequest struct {
-	MagnetLink string
-	Category   string
-	ChatId     int64
+	// Display name of the torrent, if known.
+	Name        string    `json:"name,omitempty"`
+	MagnetLink  string    `json:"magnet_link,omitempty"`
+	TorrentFile []byte    `json:"torrent_file,omitempty"`
+	Category    string    `json:"category"`
+	ChatId      int64     `json:"chat_id"`
+	Username    string    `json:"username"`
+	TorrentId   string    `json:"torrent_id"`
+	AddedAt     time.Time `json:"added_at"`
+	// ID of the message that shows the progress of the download. Zero if there is none.
+	StatusMessageId int `json:"status_message_id,omitempty"`
+	// Set while the torrent is stopped by the user.
+	Paused bool `json:"paused,omitempty"`
+	// Why the download failed. Empty unless it failed.
+	Error string `json:"error,omitempty"`
+	// Set once the completion has been reported to the user.
+	Completed bool `json:"completed,omitempty"`
+	// Why the files could not be placed into the library. Cleared once they are placed.
+	MoveError string `json:"move_error,omitempty"`
+	// Where the files were placed in the library. Empty until they are placed.
+	LibraryPath string `json:"library_path,omitempty"`
 }
 
 func (request DownloadRequest) ToString() string {
+	if request.Name != "" {
+		return fmt.Sprintf("%s [%s]", request.Name, request.Category)
+	}
+	if request.TorrentFile != nil {
+		return fmt.Sprintf("torrent file [%s]", request.Category)
+	}
 	return fmt.Sprintf("magnet [%s]", request.Category)
 }
 
+// DownloadStatus is a simplified view of the torrent status reported by rain.
+type DownloadStatus int
+
+const (
+	StatusDownloadingMetadata DownloadStatus = iota
+	StatusVerifying
+	StatusDownloading
+	StatusSeeding
+	StatusStopped
+	StatusError
+	StatusQueued
+	StatusPaused
+)
+
+func (s DownloadStatus) String() string {
+	switch s {
+	case StatusDownloadingMetadata:
+		return "downloading metadata"
+	case StatusVerifying:
+		return "verifying"
+	case StatusDownloading:
+		return "downloading"
+	case StatusSeeding:
+		return "seeding"
+	case StatusStopped:
+		return "stopped"
+	case StatusError:
+		return "error"
+	case StatusQueued:
+		return "queued"
+	case StatusPaused:
+		return "paused"
+	}
+	return "unknown"
+}
+
 type DownloadListEntry struct {
-	Name      string
-	TorrentId string
-	Category  string
+	Name           string
+	TorrentId      string
+	Category       string
+	Status         DownloadStatus
+	Error          error
+	BytesCompleted int64
+	BytesTotal     int64
+	DownloadSpeed  int // Bytes per second.
+	UploadSpeed    int // Bytes per second.
+	Ratio          float64
+	Peers          int
+	// Number of connected peers that are uploading to us.
+	// Rain does not report which peers have the whole torrent, so this approximates the seed count.
+	Seeds int
+	// Nil if the ETA is unknown.
+	ETA *time.Duration
+	// Position in the download queue starting from 1. Zero if the torrent is not queued.
+	QueuePosition int
+	// The files are in the library already, the torrent only keeps seeding.
+	InLibrary bool
 }
 
 type Downloader struct {
 	config  *config.Config
 	session *torrent.Session
+	store   *Store
 	// Stores the mapping between torrent ID and the download request.
+	// Mirrored in the store to survive restarts.
 	downloads map[string]*DownloadRequest
-	reply     ReplyFunc
-	mutex     sync.Mutex
+	// Torrents handed over to post-processing.
+	processing   map[string]struct{}
+	postprocessC chan string
+	// Serializes placing files into the library, so that concurrent moves do not pick the same name.
+	libraryMutex sync.Mutex
+	// Serializes edits of status messages sent outside d.mutex, so that a late progress report
+	// does not overwrite the final text. Taken before d.mutex, never while holding it.
+	statusMutex sync.Mutex
+	// Torrents added by Prepare that are waiting for the user to pick a category.
+	pending map[string]time.Time
+	// IDs of torrents waiting for a free download slot, in the order they will be started.
+	// Mirrored in the store to survive restarts.
+	queue     []string
+	messenger Messenger
+	// Nil if no media server is configured.
+	mediaServer *mediaserver.Client
+	// Cancelled on shutdown. Stops background work such as media server refresh retries.
+	ctx   context.Context
+	mutex sync.Mutex
 }
 
-func NewDownloader(ctx context.Context, cfg *config.Config, reply ReplyFunc) (*Downloader, error) {
+func NewDownloader(ctx context.Context, cfg *config.Config, messenger Messenger) (*Downloader, error) {
 	config := torrent.DefaultConfig
 	config.DataDir = cfg.WorkDir
 	config.Database = cfg.DatabasePath
 	config.FilePermissions = 0o755
 
-	session, err := torrent.NewSession(config)
+	store, err := OpenStore(cfg.StateDatabasePath())
+	if err != nil {
+		return nil, err
+	}
+	// Close the store and the session on every error below. The downloader owns them once it is made.
+	var session *torrent.Session
+	ok := false
+	defer func() {
+		if ok {
+			return
+		}
+		if session != nil {
+			session.Close()
+		}
+		store.Close()
+	}()
+
+	// The session resumes running torrents as soon as it is created, so their files have to be back in the work
+	// directory by then. Otherwise rain recreates half-moved files under the feet of the rollback.
+	log.Println("Rolling back unfinished placements")
+	err = rollBackJournal(store)
+	if err != nil {
+		return nil, fmt.Errorf("could not roll back unfinished placements: %w", err)
+	}
+
+	session, err = torrent.NewSession(config)
 	if err != nil {
 		return nil, fmt.Errorf("could not create torrent session: %w", err)
 	}
 
-	log.Println("Cleaning up state from previous runs")
+	downloads, err := store.LoadRequests()
+	if err != nil {
+		return nil, fmt.Errorf("could not load download requests: %w", err)
+	}
+
+	log.Println("Reconciling state from previous runs")
 	torrents := session.ListTorrents()
 	for _, torrent := range torrents {
-		log.Printf("Removing leftover torrent %s", torrent.ID())
+		if _, found := downloads[torrent.ID()]; found {
+			log.Printf("Resuming torrent %s", torrent.Name())
+			continue
+		}
+		log.Printf("Removing orphaned torrent %s", torrent.ID())
 		err = session.RemoveTorrent(torrent.ID())
 		if err != nil {
 			return nil, fmt.Errorf("could not remove torrent %s: %w", torrent.ID(), err)
 		}
 	}
+	for torrentId := range downloads {
+		if session.GetTorrent(torrentId) != nil {
+			continue
+		}
+		log.Printf("Forgetting download request for missing torrent %s", torrentId)
+		delete(downloads, torrentId)
+		err = store.DeleteRequest(torrentId)
+		if err != nil {
+			return nil, fmt.Errorf("could not delete download request %s: %w", torrentId, err)
+		}
+	}
 
-	log.Println("Cleaning up work directory")
-	err = removeDirectoryContents(cfg.WorkDir)
+	storedQueue, err := store.LoadQueue()
+	if err != nil {
+		return nil, fmt.Errorf("could not load download queue: %w", err)
+	}
+	queue := make([]string, 0, len(storedQueue))
+	for _, torrentId := range storedQueue {
+		if _, found := downloads[torrentId]; found {
+			queue = append(queue, torrentId)
+		}
+	}
+
+	log.Println("Cleaning up orphaned data in work directory")
+	err = removeOrphanedData(cfg.WorkDir, downloads)
 	if err != nil {
 		return nil, fmt.Errorf("could not clean up work directory %s: %w", cfg.WorkDir, err)
 	}
 
 	d := Downloader{
-		config:    cfg,
-		session:   session,
-		downloads: make(map[string]*DownloadRequest),
-		reply:     reply,
+		config:       cfg,
+		session:      session,
+		store:        store,
+		downloads:    downloads,
+		pending:      make(map[string]time.Time),
+		queue:        queue,
+		messenger:    messenger,
+		processing:   make(map[string]struct{}),
+		postprocessC: make(chan string, postprocessQueueSize),
+		ctx:          ctx,
+	}
+	if cfg.MediaServer != nil {
+		d.mediaServer = mediaserver.NewClient(cfg.MediaServer)
+	}
+	ok = true
+	for _, torr := range session.ListTorrents() {
+		if stats := torr.Stats(); stats.Status != torrent.Stopped {
+			d.watch(torr)
+		}
+	}
+	for i := 0; i < postprocessWorkers; i++ {
+		go d.RunPostprocessWorker(ctx)
 	}
 	go d.RunCleanupLoop(ctx)
+	go d.RunProgressLoop(ctx)
 	return &d, nil
 }
 
 func (d *Downloader) Shutdown() {
 	d.session.Close()
+	d.store.Close()
 }
 
 func (d *Downloader) RunCleanupLoop(ctx context.Context) {
+	err := d.Cleanup()
+	if err != nil {
+		log.Printf("Cleanup error: %s", err.Error())
+	}
 	for {
 		select {
 		case <-ctx.Done():
 			log.Println("Termination signal received, shutting down the cleanup loop")
 			return
-		case <-time.After(time.Second * 5):
+		case <-time.After(cleanupInterval):
 			err := d.Cleanup()
 			if err != nil {
 				log.Printf("Cleanup error: %s", err.Error())
@@ -101,135 +292,140 @@ func (d *Downloader) RunCleanupLoop(ctx context.Context) {
 
 func (d *Downloader) Add(req *DownloadRequest) error {
 	d.mutex.Lock()
-	defer d.mutex.Unlock()
-
-	d.reply(req.ChatId, "Starting download of "+req.ToString())
-
-	torr, err := d.session.AddURI(req.MagnetLink, nil)
+	queued := !d.hasFreeSlot()
+	text := "Starting download of " + req.ToString()
+	if queued {
+		text = fmt.Sprintf("Queued download of %s (position %d)", req.ToString(), len(d.queue)+1)
+	}
+	torr, err := d.startTorrent(req, queued)
 	if err != nil {
-		return fmt.Errorf("could not add torrent to session: %w", err)
+		d.mutex.Unlock()
+		return err
 	}
+	if queued {
+		d.queue = append(d.queue, torr.ID())
+		d.persistQueue()
+	}
+	req.TorrentId = torr.ID()
+	req.AddedAt = torr.AddedAt()
 	d.downloads[torr.ID()] = req
+	d.persistRequest(req)
+	torrentId, chatId := req.TorrentId, req.ChatId
+	d.mutex.Unlock()
+
+	d.attachStatusMessage(torrentId, chatId, text)
 	return nil
 }
 
-func (d *Downloader) Cleanup() error {
-	d.mutex.Lock()
-	defer d.mutex.Unlock()
-
-	torrents := d.session.ListTorrents()
-	for _, torr := range torrents {
-		stats := torr.Stats()
-		if stats.Status == torrent.Seeding || stats.Status == torrent.Stopped {
-			log.Printf("Removing completed torrent %s", torr.Name())
-
-			category := config.UnsortedCategory
-			req, found := d.downloads[torr.ID()]
-			if found {
-				d.reply(req.ChatId, fmt.Sprintf("Download of [%s] %s completed", req.Category, torr.Name()))
-				log.Printf("Found download request for torrent %s, category %s", torr.ID(), req.Category)
-				category = req.Category
-			} else {
-				// TODO: Store the requests in a persistent storage to recover from restarts.
-				log.Printf("Could not find download request for torrent %s", torr.Name())
-			}
-
-			targetDir := d.GetTargetDir(category)
-			err := d.MoveDownloadedFiles(torr.Dir(), targetDir)
-			if err != nil {
-				log.Printf("Could not move downloaded files: %s", err.Error())
-				continue
+// startTorrent starts the torrent prepared for the request or adds a new one.
+// Queued torrents are added stopped. Must be called under d.mutex.
+func (d *Downloader) startTorrent(req *DownloadRequest, queued bool) (*torrent.Torrent, error) {
+	if req.TorrentId != "" {
+		torr := d.session.GetTorrent(req.TorrentId)
+		if torr != nil {
+			delete(d.pending, req.TorrentId)
+			if queued {
+				return torr, nil
 			}
-
-			log.Printf("Removing torrent %s from session", torr.ID())
-			err = d.session.RemoveTorrent(torr.ID())
+			err := torr.Start()
 			if err != nil {
-				log.Printf("could not remove torrent from session: %s", err)
-				continue
+				return nil, fmt.Errorf("could not start torrent: %w", err)
 			}
+			d.watch(torr)
+			return torr, nil
 		}
+		log.Printf("Prepared torrent %s is gone, adding it again", req.TorrentId)
 	}
-	return nil
-}
-
-// NewPath must be called under d.mutex. Returns full path.
-func (d *Downloader) NewPath(parentDir string, desiredName string) (string, error) {
-	for index := 0; ; index++ {
-		path := path.Join(parentDir, desiredName)
-		if index > 0 {
-			path = fmt.Sprintf("%s_%d", path, index)
-		}
-		_, err := os.Stat(path)
-		if os.IsNotExist(err) {
-			return path, nil
-		} else if err != nil {
-			return "", err
-		}
-	}
-}
 
-// SafeMkdir must be called under d.mutex.
-func (d *Downloader) SafeMkdir(parentDir string, desiredName string) (string, error) {
-	newPath, err := d.NewPath(parentDir, desiredName)
-	if err == nil {
-		err = os.Mkdir(newPath, 0o755)
+	options := &torrent.AddTorrentOptions{Stopped: queued}
+	var torr *torrent.Torrent
+	var err error
+	if req.TorrentFile != nil {
+		torr, err = d.session.AddTorrent(bytes.NewReader(req.TorrentFile), options)
+	} else {
+		torr, err = d.session.AddURI(req.MagnetLink, options)
 	}
 	if err != nil {
-		return "", err
+		return nil, fmt.Errorf("could not add torrent to session: %w", err)
 	}
-	return newPath, nil
-}
-
-// SafeMove must be called under d.mutex.
-func (d *Downloader) SafeMove(src string, destDir string) (string, error) {
-	log.Printf("Moving %s to %s", src, destDir)
-	base := path.Base(src)
-	dest, err := d.NewPath(destDir, base)
-	if err != nil {
-		return "", err
+	if !queued {
+		d.watch(torr)
 	}
-	err = os.Rename(src, dest)
-	if err != nil {
-		return "", err
-	}
-	return dest, nil
+	return torr, nil
 }
 
-func (d *Downloader) GetTargetDir(category string) string {
-	targetDir, found := d.config.TargetDirs[category]
-	if !found {
-		return d.config.TargetDirs[config.UnsortedCategory]
-	}
-	return targetDir
+// Cleanup is a safety net for the torrent watchers. It hands over torrents that completed or finished seeding
+// to post-processing, reports failures and starts queued torrents.
+func (d *Downloader) Cleanup() error {
+	d.mutex.Lock()
+	notices := d.cleanup()
+	d.mutex.Unlock()
+
+	d.sendNotices(notices)
+	return nil
 }
 
-func (d *Downloader) MoveDownloadedFiles(srcDir string, destDir string) error {
-	fileInfos, err := os.ReadDir(srcDir)
-	if err != nil {
-		return nil
-	}
-	entriesToMove := make([]string, 0, len(fileInfos))
-	for _, fileInfo := range fileInfos {
-		entry := fileInfo.Name()
-		if strings.HasSuffix(entry, ".log") {
+// cleanup must be called under d.mutex. Returns the notices for the users.
+func (d *Downloader) cleanup() []notice {
+	d.discardStalePending()
+
+	notices := make([]notice, 0)
+	torrents := d.session.ListTorrents()
+	for _, torr := range torrents {
+		if _, found := d.pending[torr.ID()]; found {
 			continue
 		}
-		entriesToMove = append(entriesToMove, entry)
-	}
-	if len(entriesToMove) > 1 {
-		destDir, err = d.SafeMkdir(destDir, "torrent")
-		if err != nil {
-			return fmt.Errorf("could not create directory %s: %w", destDir, err)
+		if d.queuePosition(torr.ID()) > 0 {
+			continue
 		}
-	}
-	for _, entry := range entriesToMove {
-		src := path.Join(srcDir, entry)
-		_, err := d.SafeMove(src, destDir)
-		if err != nil {
-			return fmt.Errorf("could not move %s to %s: %w", src, destDir, err)
+		req, found := d.downloads[torr.ID()]
+		if !found {
+			log.Printf("Could not find download request for torrent %s", torr.Name())
+			req = &DownloadRequest{TorrentId: torr.ID(), Category: config.UnsortedCategory}
+			d.downloads[torr.ID()] = req
+			d.persistRequest(req)
+		}
+		if req.Paused || req.Error != "" {
+			// Paused and failed torrents stay in the session until the user acts on them.
+			continue
+		}
+		stats := torr.Stats()
+		if stats.Status != torrent.Seeding && stats.Status != torrent.Stopped {
+			continue
+		}
+
+		if req.Completed {
+			policy := d.config.SeedingPolicy(req.Category)
+			if stats.Status == torrent.Seeding && !policy.Satisfied(seedRatio(stats), stats.SeededFor) {
+				// Hardlinks and copies are placed while seeding, so retry a failed placement right away.
+				if req.LibraryPath == "" && d.config.Placement(req.Category) != config.PlacementMove {
+					d.schedulePostprocess(torr.ID())
+				}
+				continue
+			}
+			d.schedulePostprocess(torr.ID())
+			continue
+		}
+		if stats.Error != nil {
+			notices = append(notices, d.fail(torr, req, stats.Error)...)
+			continue
+		}
+		if stats.Bytes.Total == 0 || stats.Bytes.Completed < stats.Bytes.Total {
+			// Stopped before completion, e.g. it has not started yet.
+			continue
 		}
+		d.schedulePostprocess(torr.ID())
 	}
-	return nil
+
+	d.startQueued()
+	return notices
+}
+
+func seedRatio(stats torrent.Stats) float64 {
+	if stats.Bytes.Total == 0 {
+		return 0
+	}
+	return float64(stats.Bytes.Uploaded) / float64(stats.Bytes.Total)
 }
 
 func (d *Downloader) List() []DownloadListEntry {
@@ -237,18 +433,12 @@ func (d *Downloader) List() []DownloadListEntry {
 	defer d.mutex.Unlock()
 
 	torrents := d.session.ListTorrents()
-	entries := make([]DownloadListEntry, len(torrents))
-	for i, torr := range d.session.ListTorrents() {
-		category := config.UnsortedCategory
-		req, found := d.downloads[torr.ID()]
-		if found {
-			category = req.Category
-		}
-		entries[i] = DownloadListEntry{
-			Name:      torr.Name(),
-			TorrentId: torr.ID(),
-			Category:  category,
+	entries := make([]DownloadListEntry, 0, len(torrents))
+	for _, torr := range torrents {
+		if _, found := d.pending[torr.ID()]; found {
+			continue
 		}
+		entries = append(entries, d.listEntry(torr))
 	}
 
 	// Sort to make the list stable.
@@ -259,23 +449,138 @@ func (d *Downloader) List() []DownloadListEntry {
 	return entries
 }
 
-func (d *Downloader) Cancel(torrentId string) error {
+// Entry returns the status of a single download.
+func (d *Downloader) Entry(torrentId string) (DownloadListEntry, error) {
 	d.mutex.Lock()
 	defer d.mutex.Unlock()
 
+	torr := d.session.GetTorrent(torrentId)
+	_, pending := d.pending[torrentId]
+	if torr == nil || pending {
+		return DownloadListEntry{}, fmt.Errorf("torrent %s not found", torrentId)
+	}
+	return d.listEntry(torr), nil
+}
+
+// listEntry must be called under d.mutex.
+func (d *Downloader) listEntry(torr *torrent.Torrent) DownloadListEntry {
+	category := config.UnsortedCategory
+	req, found := d.downloads[torr.ID()]
+	if found {
+		category = req.Category
+	}
+	entry := makeListEntry(torr, category)
+	if position := d.queuePosition(torr.ID()); position > 0 {
+		entry.Status = StatusQueued
+		entry.QueuePosition = position
+	}
+	if found && req.Paused {
+		entry.Status = StatusPaused
+	}
+	entry.InLibrary = found && req.LibraryPath != ""
+	if found && req.Error != "" {
+		entry.Status = StatusError
+		entry.Error = errors.New(req.Error)
+	}
+	return entry
+}
+
+func makeListEntry(torr *torrent.Torrent, category string) DownloadListEntry {
+	stats := torr.Stats()
+	entry := DownloadListEntry{
+		Name:           stats.Name,
+		TorrentId:      torr.ID(),
+		Category:       category,
+		Status:         downloadStatus(stats),
+		Error:          stats.Error,
+		BytesCompleted: stats.Bytes.Completed,
+		BytesTotal:     stats.Bytes.Total,
+		DownloadSpeed:  stats.Speed.Download,
+		UploadSpeed:    stats.Speed.Upload,
+		Ratio:          seedRatio(stats),
+		Peers:          stats.Peers.Total,
+		ETA:            stats.ETA,
+	}
+	if stats.Peers.Total > 0 {
+		for _, peer := range torr.Peers() {
+			if peer.Downloading {
+				entry.Seeds++
+			}
+		}
+	}
+	return entry
+}
+
+func downloadStatus(stats torrent.Stats) DownloadStatus {
+	if stats.Error != nil {
+		return StatusError
+	}
+	switch stats.Status {
+	case torrent.DownloadingMetadata:
+		return StatusDownloadingMetadata
+	case torrent.Allocating, torrent.Verifying:
+		return StatusVerifying
+	case torrent.Downloading:
+		return StatusDownloading
+	case torrent.Seeding:
+		return StatusSeeding
+	}
+	return StatusStopped
+}
+
+func (d *Downloader) Cancel(torrentId string) error {
+	d.mutex.Lock()
+	if _, found := d.processing[torrentId]; found {
+		d.mutex.Unlock()
+		return fmt.Errorf("torrent %s is being moved into the library", torrentId)
+	}
 	err := d.session.RemoveTorrent(torrentId)
 	if err != nil {
+		d.mutex.Unlock()
 		return fmt.Errorf("could not remove torrent %s: %w", torrentId, err)
 	}
+	var notices []notice
+	if req, found := d.downloads[torrentId]; found {
+		notices = d.finishStatusMessage(req, "Cancelled download of "+req.ToString())
+	}
+	d.forget(torrentId)
+	d.startQueued()
+	d.mutex.Unlock()
+
+	d.sendNotices(notices)
 	return nil
 }
 
-func removeDirectoryContents(dir string) error {
+// persistRequest must be called under d.mutex.
+func (d *Downloader) persistRequest(req *DownloadRequest) {
+	err := d.store.PutRequest(req)
+	if err != nil {
+		log.Printf("Could not persist download request for torrent %s: %s", req.TorrentId, err)
+	}
+}
+
+// forget must be called under d.mutex.
+func (d *Downloader) forget(torrentId string) {
+	delete(d.downloads, torrentId)
+	d.dequeue(torrentId)
+	err := d.store.DeleteRequest(torrentId)
+	if err != nil {
+		log.Printf("Could not delete download request for torrent %s: %s", torrentId, err)
+	}
+}
+
+// removeOrphanedData removes everything in the work directory that does not belong to a known download.
+// Rain keeps the data of each torrent in a subdirectory named after the torrent ID.
+func removeOrphanedData(dir string, downloads map[string]*DownloadRequest) error {
 	dirEntries, err := os.ReadDir(dir)
 	if err != nil {
 		return err
 	}
 	for _, entry := range dirEntries {
+		if _, found := downloads[entry.Name()]; found {
+			continue
+		}
+		log.Printf("Removing orphaned data %s", entry.Name())
 		err = os.RemoveAll(path.Join(dir, entry.Name()))
 		if err != nil {
 			return err